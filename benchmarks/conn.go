@@ -0,0 +1,81 @@
+package benchmarks
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/doubledutch/mux"
+)
+
+// countingConn counts the bytes written to a net.Conn
+type countingConn struct {
+	net.Conn
+	written atomic.Int64
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// Conn benchmarks sending frames from one mux.Conn to another, reporting the
+// bytes written to the wire for each frame
+func Conn(b *testing.B, pool mux.Pool, newConn func(conn net.Conn) (mux.Conn, error)) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			b.Error(err)
+		}
+		accepted <- conn
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	counted := &countingConn{Conn: conn}
+	client, err := newConn(counted)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	server, err := newConn(<-accepted)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer server.Shutdown()
+
+	ch := make(chan string, 1)
+	server.Receive(mux.LogType, pool.NewReceiver(ch))
+	go server.Recv()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < b.N; i++ {
+			<-ch
+		}
+		close(done)
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := client.Send(mux.LogType, "hello"); err != nil {
+			b.Fatal(err)
+		}
+	}
+	<-done
+	b.StopTimer()
+
+	b.ReportMetric(float64(counted.written.Load())/float64(b.N), "wire-B/op")
+}
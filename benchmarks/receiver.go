@@ -37,3 +37,37 @@ func ValueReceiver(b *testing.B, pool mux.Pool) {
 		r.Receive([]byte("hello"))
 	}
 }
+
+// BytesReceiver benchmarks a mux.Pool receiving on a chan []byte
+func BytesReceiver(b *testing.B, pool mux.Pool) {
+	ch := make(chan []byte, 1)
+
+	r := pool.NewReceiver(ch)
+
+	go func() {
+		for _ = range ch {
+		}
+	}()
+
+	b.ReportAllocs()
+	data := []byte("hello")
+	for i := 0; i < b.N; i++ {
+		r.Receive(data)
+	}
+}
+
+// TypedReceiver benchmarks a mux.Pool using a TypedReceiver
+func TypedReceiver(b *testing.B, pool mux.Pool) {
+	ch := make(chan string, 1)
+
+	r := mux.NewTypedReceiver(ch, pool)
+
+	go func() {
+		for _ = range ch {
+		}
+	}()
+
+	for i := 0; i < b.N; i++ {
+		r.Receive([]byte("hello"))
+	}
+}
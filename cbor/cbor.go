@@ -0,0 +1,920 @@
+package cbor
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/doubledutch/mux"
+)
+
+// CBOR major types, see RFC 8949
+const (
+	majorUint   byte = 0
+	majorNegInt byte = 1
+	majorBytes  byte = 2
+	majorText   byte = 3
+	majorArray  byte = 4
+	majorMap    byte = 5
+	majorTag    byte = 6
+	majorSimple byte = 7
+
+	infoUint8      byte = 24
+	infoUint16     byte = 25
+	infoUint32     byte = 26
+	infoUint64     byte = 27
+	infoIndefinite byte = 31
+
+	simpleFalse     byte = 20
+	simpleTrue      byte = 21
+	simpleNull      byte = 22
+	simpleUndefined byte = 23
+	simpleFloat16   byte = 25
+	simpleFloat32   byte = 26
+	simpleFloat64   byte = 27
+	simpleBreak     byte = 31
+
+	// tagDateTime is an RFC 3339 date/time string
+	tagDateTime uint64 = 0
+	// tagEpoch is seconds since the epoch
+	tagEpoch uint64 = 1
+
+	// maxDepth is how deeply arrays, maps and tags may nest
+	maxDepth = 1000
+)
+
+var (
+	timeType  = reflect.TypeOf(time.Time{})
+	frameType = reflect.TypeOf(mux.Frame{})
+
+	errBreak = errors.New("cbor: unexpected break")
+)
+
+// Encoder writes CBOR values to an io.Writer
+type Encoder struct {
+	w   io.Writer
+	buf []byte
+}
+
+// NewEncoder returns an Encoder writing to w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the CBOR encoding of v.
+//
+// Struct fields are encoded like encoding/json: as a map keyed by field name,
+// or the name in a `cbor:"name"` tag. Fields tagged `cbor:"-"` are skipped,
+// as are fields tagged `cbor:",omitempty"` holding an empty value, and the
+// fields of embedded structs are promoted. []byte is encoded as a byte string,
+// time.Time as an RFC 3339 date/time string and mux.Frame as the array
+// [type, flags, data].
+func (e *Encoder) Encode(v interface{}) error {
+	b, err := appendValue(e.buf[:0], reflect.ValueOf(v))
+	e.buf = b[:0]
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(b)
+	return err
+}
+
+// Marshal returns the CBOR encoding of v
+func Marshal(v interface{}) ([]byte, error) {
+	return appendValue(nil, reflect.ValueOf(v))
+}
+
+// appendHead appends the head of a data item with major type m and
+// argument n in its shortest form
+func appendHead(b []byte, m byte, n uint64) []byte {
+	m <<= 5
+	switch {
+	case n < uint64(infoUint8):
+		return append(b, m|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, m|infoUint8, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, m|infoUint16), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, m|infoUint32), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, m|infoUint64), n)
+}
+
+func appendSimple(b []byte, s byte) []byte {
+	return append(b, majorSimple<<5|s)
+}
+
+func appendValue(b []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() {
+		return appendSimple(b, simpleNull), nil
+	}
+	switch v.Type() {
+	case timeType:
+		t := v.Interface().(time.Time)
+		b = appendHead(b, majorTag, tagDateTime)
+		s := t.Format(time.RFC3339Nano)
+		return append(appendHead(b, majorText, uint64(len(s))), s...), nil
+	case frameType:
+		f := v.Interface().(mux.Frame)
+		b = appendHead(b, majorArray, 3)
+		b = appendHead(b, majorUint, uint64(f.Type))
+		b = appendHead(b, majorUint, uint64(f.Flags))
+		return append(appendHead(b, majorBytes, uint64(len(f.Data))), f.Data...), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return appendSimple(b, simpleTrue), nil
+		}
+		return appendSimple(b, simpleFalse), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := v.Int()
+		if i < 0 {
+			return appendHead(b, majorNegInt, uint64(-(i + 1))), nil
+		}
+		return appendHead(b, majorUint, uint64(i)), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendHead(b, majorUint, v.Uint()), nil
+	case reflect.Float32:
+		b = appendSimple(b, simpleFloat32)
+		return binary.BigEndian.AppendUint32(b, math.Float32bits(float32(v.Float()))), nil
+	case reflect.Float64:
+		b = appendSimple(b, simpleFloat64)
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(v.Float())), nil
+	case reflect.String:
+		return append(appendHead(b, majorText, uint64(v.Len())), v.String()...), nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return appendSimple(b, simpleNull), nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return append(appendHead(b, majorBytes, uint64(v.Len())), v.Bytes()...), nil
+		}
+		return appendArray(b, v)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b = appendHead(b, majorBytes, uint64(v.Len()))
+			for i := 0; i < v.Len(); i++ {
+				b = append(b, byte(v.Index(i).Uint()))
+			}
+			return b, nil
+		}
+		return appendArray(b, v)
+	case reflect.Map:
+		if v.IsNil() {
+			return appendSimple(b, simpleNull), nil
+		}
+		return appendMap(b, v)
+	case reflect.Struct:
+		return appendStruct(b, v)
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return appendSimple(b, simpleNull), nil
+		}
+		return appendValue(b, v.Elem())
+	}
+	return b, fmt.Errorf("cbor: unsupported type %s", v.Type())
+}
+
+func appendArray(b []byte, v reflect.Value) ([]byte, error) {
+	b = appendHead(b, majorArray, uint64(v.Len()))
+	for i := 0; i < v.Len(); i++ {
+		var err error
+		if b, err = appendValue(b, v.Index(i)); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+func appendMap(b []byte, v reflect.Value) ([]byte, error) {
+	b = appendHead(b, majorMap, uint64(v.Len()))
+	iter := v.MapRange()
+	for iter.Next() {
+		var err error
+		if b, err = appendValue(b, iter.Key()); err != nil {
+			return b, err
+		}
+		if b, err = appendValue(b, iter.Value()); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+func appendStruct(b []byte, v reflect.Value) ([]byte, error) {
+	fields := cachedFields(v.Type())
+
+	n := 0
+	for _, f := range fields {
+		if !f.omitEmpty || !isEmpty(v.FieldByIndex(f.index)) {
+			n++
+		}
+	}
+
+	b = appendHead(b, majorMap, uint64(n))
+	for _, f := range fields {
+		fv := v.FieldByIndex(f.index)
+		if f.omitEmpty && isEmpty(fv) {
+			continue
+		}
+		b = append(appendHead(b, majorText, uint64(len(f.name))), f.name...)
+		var err error
+		if b, err = appendValue(b, fv); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+// isEmpty reports whether v is empty the way encoding/json's omitempty does
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// field is an encoded struct field
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var fieldCache sync.Map // map[reflect.Type][]field
+
+func cachedFields(t reflect.Type) []field {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.([]field)
+	}
+	fields, _ := fieldCache.LoadOrStore(t, typeFields(t, nil))
+	return fields.([]field)
+}
+
+// typeFields returns the encoded fields of t, promoting the fields of
+// embedded structs
+func typeFields(t reflect.Type, index []int) []field {
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("cbor")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		fieldIndex := append(append([]int(nil), index...), i)
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			fields = append(fields, typeFields(sf.Type, fieldIndex)...)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, field{
+			name:      name,
+			index:     fieldIndex,
+			omitEmpty: opts == "omitempty",
+		})
+	}
+	return fields
+}
+
+// reader is what the Decoder reads from
+type reader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// Decoder reads CBOR values from an io.Reader
+type Decoder struct {
+	r reader
+
+	// depth is how many arrays, maps and tags are being decoded
+	depth int
+}
+
+// NewDecoder returns a Decoder reading from r. If r isn't an io.ByteReader
+// it is buffered, so the Decoder may read past the values it decodes.
+func NewDecoder(r io.Reader) *Decoder {
+	rr, ok := r.(reader)
+	if !ok {
+		rr = bufio.NewReader(r)
+	}
+	return &Decoder{r: rr}
+}
+
+// Decode reads the next CBOR value into v, which must be a non-nil pointer.
+// Maps are decoded into structs by matching keys to field names,
+// case-insensitively when there isn't an exact match. Unknown keys are
+// skipped. Definite and indefinite lengths are both accepted.
+func (d *Decoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("cbor: Decode of non-pointer %T", v)
+	}
+	return d.value(rv.Elem())
+}
+
+// Unmarshal decodes the CBOR value in b into v
+func Unmarshal(b []byte, v interface{}) error {
+	return NewDecoder(&byteReader{b: b}).Decode(v)
+}
+
+// byteReader is a reader over a []byte
+type byteReader struct {
+	b []byte
+}
+
+func (r *byteReader) Read(p []byte) (int, error) {
+	if len(r.b) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.b)
+	r.b = r.b[n:]
+	return n, nil
+}
+
+// Len returns the number of bytes left
+func (r *byteReader) Len() int {
+	return len(r.b)
+}
+
+func (r *byteReader) ReadByte() (byte, error) {
+	if len(r.b) == 0 {
+		return 0, io.EOF
+	}
+	c := r.b[0]
+	r.b = r.b[1:]
+	return c, nil
+}
+
+// head is the initial bytes of a data item
+type head struct {
+	major byte
+	info  byte
+	// arg is the value, length or simple value the head carries
+	arg uint64
+}
+
+func (h head) indefinite() bool {
+	return h.info == infoIndefinite
+}
+
+func (h head) isNull() bool {
+	return h.major == majorSimple && (h.info == simpleNull || h.info == simpleUndefined)
+}
+
+func (h head) isBreak() bool {
+	return h.major == majorSimple && h.info == simpleBreak
+}
+
+// length checks a length read from the input, which can't exceed the bytes
+// left when the reader knows them, otherwise mux.MaxFrameSize
+func (d *Decoder) length(n uint64) error {
+	if l, ok := d.r.(interface{ Len() int }); ok {
+		if n > uint64(l.Len()) {
+			return fmt.Errorf("cbor: length %d exceeds the %d bytes left", n, l.Len())
+		}
+	} else if n > mux.MaxFrameSize {
+		return fmt.Errorf("cbor: length %d too large", n)
+	}
+	return nil
+}
+
+// nest enters an array, map or tag, failing beyond maxDepth. leave must be
+// called once it's decoded.
+func (d *Decoder) nest() error {
+	if d.depth >= maxDepth {
+		return fmt.Errorf("cbor: nested deeper than %d", maxDepth)
+	}
+	d.depth++
+	return nil
+}
+
+func (d *Decoder) leave() {
+	d.depth--
+}
+
+// read reads n bytes, n must have been checked by length
+func (d *Decoder) read(n uint64) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+func (d *Decoder) head() (head, error) {
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return head{}, err
+	}
+	h := head{major: c >> 5, info: c & 0x1f}
+
+	var n int
+	switch {
+	case h.info < infoUint8:
+		h.arg = uint64(h.info)
+		return h, nil
+	case h.info <= infoUint64:
+		n = 1 << (h.info - infoUint8)
+	case h.info == infoIndefinite:
+		switch h.major {
+		case majorBytes, majorText, majorArray, majorMap, majorSimple:
+			return h, nil
+		}
+		return head{}, fmt.Errorf("cbor: invalid indefinite length for major type %d", h.major)
+	default:
+		return head{}, fmt.Errorf("cbor: invalid additional information %d", h.info)
+	}
+
+	b, err := d.read(uint64(n))
+	if err != nil {
+		return head{}, err
+	}
+	switch n {
+	case 1:
+		h.arg = uint64(b[0])
+	case 2:
+		h.arg = uint64(binary.BigEndian.Uint16(b))
+	case 4:
+		h.arg = uint64(binary.BigEndian.Uint32(b))
+	default:
+		h.arg = binary.BigEndian.Uint64(b)
+	}
+	return h, nil
+}
+
+func (d *Decoder) value(v reflect.Value) error {
+	h, err := d.head()
+	if err != nil {
+		return err
+	}
+	return d.valueHead(h, v)
+}
+
+func (d *Decoder) valueHead(h head, v reflect.Value) error {
+	if h.isBreak() {
+		return errBreak
+	}
+	if h.isNull() {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.valueHead(h, v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return fmt.Errorf("cbor: cannot decode into non-empty interface %s", v.Type())
+		}
+		x, err := d.anyHead(h)
+		if err != nil {
+			return err
+		}
+		if x == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(x))
+		}
+		return nil
+	}
+
+	switch v.Type() {
+	case timeType:
+		return d.time(h, v)
+	case frameType:
+		return d.frame(h, v)
+	}
+
+	switch h.major {
+	case majorUint, majorNegInt:
+		return d.integer(h, v)
+	case majorBytes, majorText:
+		b, err := d.str(h)
+		if err != nil {
+			return err
+		}
+		return setBytes(h, b, v)
+	case majorArray:
+		return d.array(h, v)
+	case majorMap:
+		return d.mapValue(h, v)
+	case majorTag:
+		// Only time tags are understood, others decode as their content
+		if err := d.nest(); err != nil {
+			return err
+		}
+		defer d.leave()
+		return d.value(v)
+	}
+	return d.simple(h, v)
+}
+
+func decodeError(h head, v reflect.Value) error {
+	return fmt.Errorf("cbor: cannot decode major type %d into %s", h.major, v.Type())
+}
+
+func (d *Decoder) integer(h head, v reflect.Value) error {
+	neg := h.major == majorNegInt
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if h.arg > math.MaxInt64 {
+			return fmt.Errorf("cbor: integer overflows %s", v.Type())
+		}
+		i := int64(h.arg)
+		if neg {
+			i = -1 - i
+		}
+		if v.OverflowInt(i) {
+			return fmt.Errorf("cbor: %d overflows %s", i, v.Type())
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if neg {
+			return fmt.Errorf("cbor: cannot decode negative integer into %s", v.Type())
+		}
+		if v.OverflowUint(h.arg) {
+			return fmt.Errorf("cbor: %d overflows %s", h.arg, v.Type())
+		}
+		v.SetUint(h.arg)
+	case reflect.Float32, reflect.Float64:
+		f := float64(h.arg)
+		if neg {
+			f = -1 - f
+		}
+		v.SetFloat(f)
+	default:
+		return decodeError(h, v)
+	}
+	return nil
+}
+
+// str reads a byte or text string, joining the chunks of an indefinite one
+func (d *Decoder) str(h head) ([]byte, error) {
+	if !h.indefinite() {
+		if err := d.length(h.arg); err != nil {
+			return nil, err
+		}
+		return d.read(h.arg)
+	}
+
+	var b []byte
+	for {
+		chunk, err := d.head()
+		if err != nil {
+			return nil, err
+		}
+		if chunk.isBreak() {
+			return b, nil
+		}
+		if chunk.major != h.major || chunk.indefinite() {
+			return nil, fmt.Errorf("cbor: invalid chunk of major type %d", chunk.major)
+		}
+		if err := d.length(chunk.arg); err != nil {
+			return nil, err
+		}
+		c, err := d.read(chunk.arg)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, c...)
+	}
+}
+
+func setBytes(h head, b []byte, v reflect.Value) error {
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(string(b))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		v.SetBytes(b)
+	case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+		if len(b) > v.Len() {
+			return fmt.Errorf("cbor: %d bytes overflow %s", len(b), v.Type())
+		}
+		reflect.Copy(v, reflect.ValueOf(b))
+		for i := len(b); i < v.Len(); i++ {
+			v.Index(i).SetUint(0)
+		}
+	default:
+		return decodeError(h, v)
+	}
+	return nil
+}
+
+// items calls item for each item of an array or map, or each pair of a map.
+// Every item takes at least a byte, so a definite count is checked as a
+// length.
+func (d *Decoder) items(h head, item func(head) error) error {
+	if err := d.nest(); err != nil {
+		return err
+	}
+	defer d.leave()
+
+	if !h.indefinite() {
+		if err := d.length(h.arg); err != nil {
+			return err
+		}
+		for i := uint64(0); i < h.arg; i++ {
+			ih, err := d.head()
+			if err != nil {
+				return err
+			}
+			if err := item(ih); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for {
+		ih, err := d.head()
+		if err != nil {
+			return err
+		}
+		if ih.isBreak() {
+			return nil
+		}
+		if err := item(ih); err != nil {
+			return err
+		}
+	}
+}
+
+func (d *Decoder) array(h head, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Slice:
+		v.SetLen(0)
+		elem := v.Type().Elem()
+		return d.items(h, func(ih head) error {
+			e := reflect.New(elem).Elem()
+			if err := d.valueHead(ih, e); err != nil {
+				return err
+			}
+			v.Set(reflect.Append(v, e))
+			return nil
+		})
+	case reflect.Array:
+		i := 0
+		err := d.items(h, func(ih head) error {
+			if i >= v.Len() {
+				return fmt.Errorf("cbor: too many elements for %s", v.Type())
+			}
+			i++
+			return d.valueHead(ih, v.Index(i-1))
+		})
+		for ; i < v.Len(); i++ {
+			v.Index(i).Set(reflect.Zero(v.Type().Elem()))
+		}
+		return err
+	}
+	return decodeError(h, v)
+}
+
+func (d *Decoder) mapValue(h head, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		return d.items(h, func(kh head) error {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := d.valueHead(kh, key); err != nil {
+				return err
+			}
+			if key.Kind() == reflect.Interface && !key.IsNil() && !key.Elem().Type().Comparable() {
+				return fmt.Errorf("cbor: invalid map key %s", key.Elem().Type())
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := d.value(elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+			return nil
+		})
+	case reflect.Struct:
+		fields := cachedFields(v.Type())
+		return d.items(h, func(kh head) error {
+			var name string
+			if err := d.valueHead(kh, reflect.ValueOf(&name).Elem()); err != nil {
+				return err
+			}
+			f, ok := findField(fields, name)
+			if !ok {
+				_, err := d.any()
+				return err
+			}
+			return d.value(v.FieldByIndex(f.index))
+		})
+	}
+	return decodeError(h, v)
+}
+
+func findField(fields []field, name string) (field, bool) {
+	for _, f := range fields {
+		if f.name == name {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.name, name) {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+// simple decodes bools and floats
+func (d *Decoder) simple(h head, v reflect.Value) error {
+	switch h.info {
+	case simpleFalse, simpleTrue:
+		if v.Kind() != reflect.Bool {
+			return decodeError(h, v)
+		}
+		v.SetBool(h.info == simpleTrue)
+		return nil
+	case simpleFloat16, simpleFloat32, simpleFloat64:
+		if v.Kind() != reflect.Float32 && v.Kind() != reflect.Float64 {
+			return decodeError(h, v)
+		}
+		v.SetFloat(h.float())
+		return nil
+	}
+	return fmt.Errorf("cbor: unsupported simple value %d", h.arg)
+}
+
+// float converts the argument of a float head
+func (h head) float() float64 {
+	switch h.info {
+	case simpleFloat16:
+		return float16(uint16(h.arg))
+	case simpleFloat32:
+		return float64(math.Float32frombits(uint32(h.arg)))
+	}
+	return math.Float64frombits(h.arg)
+}
+
+// float16 converts an IEEE 754 half-precision float
+func float16(u uint16) float64 {
+	sign := 1.0
+	if u&0x8000 != 0 {
+		sign = -1
+	}
+	exp := int(u>>10) & 0x1f
+	mant := float64(u & 0x3ff)
+
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			return math.Inf(int(sign))
+		}
+		return math.NaN()
+	}
+	return sign * math.Ldexp(mant+1024, exp-25)
+}
+
+// time decodes a date/time string or epoch tag
+func (d *Decoder) time(h head, v reflect.Value) error {
+	if h.major != majorTag {
+		return decodeError(h, v)
+	}
+
+	switch h.arg {
+	case tagDateTime:
+		var s string
+		if err := d.value(reflect.ValueOf(&s).Elem()); err != nil {
+			return err
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return fmt.Errorf("cbor: %s", err)
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	case tagEpoch:
+		var f float64
+		if err := d.value(reflect.ValueOf(&f).Elem()); err != nil {
+			return err
+		}
+		sec, frac := math.Modf(f)
+		v.Set(reflect.ValueOf(time.Unix(int64(sec), int64(frac*1e9))))
+		return nil
+	}
+	return fmt.Errorf("cbor: cannot decode tag %d into %s", h.arg, v.Type())
+}
+
+// frame decodes the array [type, flags, data]
+func (d *Decoder) frame(h head, v reflect.Value) error {
+	if h.major != majorArray || h.arg != 3 {
+		return decodeError(h, v)
+	}
+
+	var f mux.Frame
+	for _, x := range []interface{}{&f.Type, &f.Flags, &f.Data} {
+		if err := d.value(reflect.ValueOf(x).Elem()); err != nil {
+			return err
+		}
+	}
+	v.Set(reflect.ValueOf(f))
+	return nil
+}
+
+// any decodes the next value into an interface{}
+func (d *Decoder) any() (interface{}, error) {
+	h, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	return d.anyHead(h)
+}
+
+// anyHead decodes a value into its natural Go type: nil, bool, uint64,
+// int64, float64, string, []byte, []interface{},
+// map[interface{}]interface{} or time.Time. Unknown tags decode as their
+// content.
+func (d *Decoder) anyHead(h head) (interface{}, error) {
+	var v reflect.Value
+	switch h.major {
+	case majorUint:
+		return h.arg, nil
+	case majorNegInt:
+		if h.arg > math.MaxInt64 {
+			return nil, errors.New("cbor: negative integer overflows int64")
+		}
+		return -1 - int64(h.arg), nil
+	case majorBytes:
+		return d.str(h)
+	case majorText:
+		b, err := d.str(h)
+		return string(b), err
+	case majorArray:
+		var a []interface{}
+		v = reflect.ValueOf(&a).Elem()
+	case majorMap:
+		var m map[interface{}]interface{}
+		v = reflect.ValueOf(&m).Elem()
+	case majorTag:
+		if h.arg != tagDateTime && h.arg != tagEpoch {
+			if err := d.nest(); err != nil {
+				return nil, err
+			}
+			defer d.leave()
+			return d.any()
+		}
+		var t time.Time
+		v = reflect.ValueOf(&t).Elem()
+	default:
+		switch {
+		case h.isBreak():
+			return nil, errBreak
+		case h.isNull():
+			return nil, nil
+		case h.info == simpleFalse || h.info == simpleTrue:
+			return h.info == simpleTrue, nil
+		case h.info == simpleFloat16 || h.info == simpleFloat32 || h.info == simpleFloat64:
+			return h.float(), nil
+		}
+		return nil, fmt.Errorf("cbor: unsupported simple value %d", h.arg)
+	}
+
+	if err := d.valueHead(h, v); err != nil {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
@@ -0,0 +1,234 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/mux"
+)
+
+type embedded struct {
+	E int
+}
+
+type tagged struct {
+	embedded
+	Name    string   `cbor:"name"`
+	Skipped int      `cbor:"-"`
+	Empty   []string `cbor:",omitempty"`
+	hidden  int
+}
+
+// Examples from RFC 8949 Appendix A
+func TestMarshal(t *testing.T) {
+	for _, tc := range []struct {
+		v        interface{}
+		expected string
+	}{
+		{0, "00"},
+		{23, "17"},
+		{24, "1818"},
+		{1000, "1903e8"},
+		{uint64(math.MaxUint64), "1bffffffffffffffff"},
+		{-1, "20"},
+		{-1000, "3903e7"},
+		{1.1, "fb3ff199999999999a"},
+		{false, "f4"},
+		{true, "f5"},
+		{nil, "f6"},
+		{[]byte{1, 2, 3, 4}, "4401020304"},
+		{"IETF", "6449455446"},
+		{[]int{1, 2, 3}, "83010203"},
+		{map[string]int{"a": 1}, "a1616101"},
+		{time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC), "c074323031332d30332d32315432303a30343a30305a"},
+		{tagged{embedded: embedded{E: 1}, Name: "x", Skipped: 2}, "a2614501646e616d656178"},
+		{mux.Frame{Type: mux.LogType, Data: []byte{1}}, "83010041" + "01"},
+	} {
+		actual, err := Marshal(tc.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, _ := hex.DecodeString(tc.expected); !bytes.Equal(actual, expected) {
+			t.Fatalf("%v: expected %s, got %x", tc.v, tc.expected, actual)
+		}
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	for _, tc := range []struct {
+		data     string
+		expected interface{}
+	}{
+		{"f93c00", 1.0},
+		{"f97bff", 65504.0},
+		{"f90001", 5.960464477539063e-8},
+		{"fa47c35000", 100000.0},
+		{"3bffffffffffffffff", nil},
+		{"c11a514b67b0", time.Unix(1363896240, 0)},
+		{"5f42010243030405ff", []byte{1, 2, 3, 4, 5}},
+		{"7f657374726561646d696e67ff", "streaming"},
+		{"9f018202039f0405ffff", []interface{}{uint64(1), []interface{}{uint64(2), uint64(3)}, []interface{}{uint64(4), uint64(5)}}},
+		{"bf61610161629f0203ffff", map[interface{}]interface{}{"a": uint64(1), "b": []interface{}{uint64(2), uint64(3)}}},
+		{"d82076687474703a2f2f7777772e6578616d706c652e636f6d", "http://www.example.com"},
+	} {
+		b, _ := hex.DecodeString(tc.data)
+		var actual interface{}
+		err := Unmarshal(b, &actual)
+		if tc.expected == nil {
+			if err == nil {
+				t.Fatalf("%s: expected error, got %v", tc.data, actual)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", tc.data, err)
+		}
+		if !reflect.DeepEqual(actual, tc.expected) {
+			if at, ok := actual.(time.Time); !ok || !at.Equal(tc.expected.(time.Time)) {
+				t.Fatalf("%s: expected %#v, got %#v", tc.data, tc.expected, actual)
+			}
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	type inner struct {
+		A []string
+		B map[string]float64
+	}
+	type outer struct {
+		Int    int64
+		Uint   uint32
+		Bytes  []byte
+		Array  [3]byte
+		Ptr    *inner
+		Inner  inner
+		Time   time.Time
+		Any    interface{}
+		Frame  mux.Frame
+		Signed []int16
+	}
+
+	expected := outer{
+		Int:    math.MinInt64,
+		Uint:   math.MaxUint32,
+		Bytes:  bytes.Repeat([]byte{1}, 300),
+		Array:  [3]byte{1, 2, 3},
+		Ptr:    &inner{A: []string{"a", "b"}},
+		Inner:  inner{B: map[string]float64{"pi": math.Pi}},
+		Time:   time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC),
+		Any:    "any",
+		Frame:  mux.Frame{Type: 1, Flags: 2, Data: []byte{3}},
+		Signed: []int16{-200, 200},
+	}
+
+	b, err := Marshal(&expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual outer
+	if err := Unmarshal(b, &actual); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, actual)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	var i8 int8
+	if err := Unmarshal([]byte{0x18, 0xff}, &i8); err == nil {
+		t.Fatal("expected overflow error")
+	}
+
+	var u uint
+	if err := Unmarshal([]byte{0x20}, &u); err == nil {
+		t.Fatal("expected error decoding a negative integer into uint")
+	}
+
+	var s string
+	if err := Unmarshal([]byte{0x63, 'a'}, &s); err == nil {
+		t.Fatal("expected error decoding a truncated string")
+	}
+
+	if err := Unmarshal([]byte{0xf6}, s); err == nil {
+		t.Fatal("expected error decoding into a non-pointer")
+	}
+
+	// Unknown fields are skipped, names match case-insensitively
+	var args struct{ A, B int }
+	b := []byte{0xa3, 0x61, 'a', 0x01, 0x61, 'C', 0x82, 0x01, 0x02, 0x61, 'B', 0x02}
+	if err := Unmarshal(b, &args); err != nil {
+		t.Fatal(err)
+	}
+	if args.A != 1 || args.B != 2 {
+		t.Fatalf("expected {1 2}, got %v", args)
+	}
+}
+
+func TestDecodeOversized(t *testing.T) {
+	payloads := map[string][]byte{
+		"bytes": {0x5a, 0x7f, 0xff, 0xff, 0xff},
+		"text":  {0x7a, 0x7f, 0xff, 0xff, 0xff},
+		"array": {0x9a, 0x7f, 0xff, 0xff, 0xff},
+		"map":   {0xba, 0x7f, 0xff, 0xff, 0xff},
+		"chunk": {0x5f, 0x5a, 0x7f, 0xff, 0xff, 0xff, 0xff},
+		// Within mux.MaxFrameSize but not the input
+		"text short":  {0x7a, 0x00, 0x10, 0x00, 0x00, 'a'},
+		"array short": {0x9a, 0x00, 0x10, 0x00, 0x00, 0x61, 'a'},
+	}
+	targets := map[string]func() interface{}{
+		"[]string":    func() interface{} { return new([]string) },
+		"string":      func() interface{} { return new(string) },
+		"[]byte":      func() interface{} { return new([]byte) },
+		"map":         func() interface{} { return new(map[string]string) },
+		"interface{}": func() interface{} { return new(interface{}) },
+	}
+	for name, b := range payloads {
+		for target, v := range targets {
+			if err := Unmarshal(b, v()); err == nil {
+				t.Fatalf("expected error decoding %s into %s", name, target)
+			}
+			// Without knowing the bytes left, lengths are still bounded
+			r := io.MultiReader(bytes.NewReader(b))
+			if err := NewDecoder(r).Decode(v()); err == nil {
+				t.Fatalf("expected error streaming %s into %s", name, target)
+			}
+		}
+	}
+}
+
+func TestDecodeDepth(t *testing.T) {
+	for name, nest := range map[string]byte{
+		"array": 0x81,
+		"tag":   0xc6,
+	} {
+		b := append(bytes.Repeat([]byte{nest}, maxDepth), 0xf6)
+		var v interface{}
+		if err := Unmarshal(b, &v); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		b = append(bytes.Repeat([]byte{nest}, maxDepth+1), 0xf6)
+		if err := Unmarshal(b, &v); err == nil || !strings.Contains(err.Error(), "nested") {
+			t.Fatalf("%s: expected nesting error, got %v", name, err)
+		}
+		var s []interface{}
+		if err := Unmarshal(b, &s); err == nil || !strings.Contains(err.Error(), "nested") {
+			t.Fatalf("%s: expected nesting error, got %v", name, err)
+		}
+	}
+
+	// A frame of tags decodes without exhausting the stack
+	b := bytes.Repeat([]byte{0xc6}, 8<<20)
+	var v interface{}
+	if err := Unmarshal(b, &v); err == nil {
+		t.Fatal("expected nesting error")
+	}
+}
@@ -0,0 +1,11 @@
+package cbor
+
+import (
+	"testing"
+
+	"github.com/doubledutch/mux/benchmarks"
+)
+
+func BenchmarkConn(b *testing.B) {
+	benchmarks.Conn(b, new(Pool), NewDefaultConn)
+}
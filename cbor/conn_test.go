@@ -0,0 +1,115 @@
+package cbor
+
+import (
+	"testing"
+
+	"github.com/doubledutch/mux/tests"
+)
+
+func TestConnection(t *testing.T) {
+	tests.Connection(t, new(Pool), NewDefaultConn)
+}
+
+func TestShutdown(t *testing.T) {
+	tests.Shutdown(t, NewDefaultConn)
+}
+
+func TestTimeoutSend(t *testing.T) {
+	tests.TimeoutSend(t, new(Pool), NewConn)
+}
+
+func TestDroppedMessages(t *testing.T) {
+	tests.DroppedMessages(t, new(Pool), NewDefaultConn)
+}
+
+func TestStreams(t *testing.T) {
+	tests.Streams(t, NewDefaultConn)
+}
+
+func TestStreamReset(t *testing.T) {
+	tests.StreamReset(t, NewDefaultConn)
+}
+
+func TestStreamFlowControl(t *testing.T) {
+	tests.StreamFlowControl(t, NewConn)
+}
+
+func TestHeartbeat(t *testing.T) {
+	tests.Heartbeat(t, NewConn)
+}
+
+func TestGoAway(t *testing.T) {
+	tests.GoAway(t, new(Pool), NewDefaultConn)
+}
+
+func TestConnLost(t *testing.T) {
+	tests.ConnLost(t, NewDefaultConn)
+}
+
+func TestContext(t *testing.T) {
+	tests.Context(t, NewDefaultConn)
+}
+
+func TestShutdownContext(t *testing.T) {
+	tests.ShutdownContext(t, NewDefaultConn)
+}
+
+func TestSendQueue(t *testing.T) {
+	tests.SendQueue(t, new(Pool), NewConn)
+}
+
+func TestWireFormat(t *testing.T) {
+	tests.WireFormat(t, NewDefaultConn)
+}
+
+func TestSharedType(t *testing.T) {
+	tests.SharedType(t, new(Pool), NewDefaultConn)
+}
+
+func TestSendRaw(t *testing.T) {
+	tests.SendRaw(t, NewDefaultConn)
+}
+
+func TestNegotiate(t *testing.T) {
+	tests.Negotiate(t, Name)
+}
+
+func TestCapabilities(t *testing.T) {
+	tests.Capabilities(t, NewConn)
+}
+
+func TestFragments(t *testing.T) {
+	tests.Fragments(t, new(Pool), NewConn)
+}
+
+func TestReaderReceiver(t *testing.T) {
+	tests.ReaderReceiver(t, NewDefaultConn)
+}
+
+func TestReliable(t *testing.T) {
+	tests.Reliable(t, new(Pool))
+}
+
+func TestReliableUnsupported(t *testing.T) {
+	tests.ReliableUnsupported(t, NewConn)
+}
+
+func TestRegistry(t *testing.T) {
+	tests.Registry(t, NewDefaultConn)
+}
+
+func TestSendAfterTooLarge(t *testing.T) {
+	tests.SendAfterTooLarge(t, new(Pool))
+}
+
+func TestReplaceReceiver(t *testing.T) {
+	tests.ReplaceReceiver(t, new(Pool))
+}
+
+func TestTyped(t *testing.T) {
+	tests.Typed(t, NewDefaultConn)
+}
+
+func TestSchema(t *testing.T) {
+	tests.Schema(t, new(Pool), NewConn)
+}
@@ -0,0 +1,47 @@
+package cbor
+
+import (
+	"bytes"
+	"io"
+	"net"
+
+	"github.com/doubledutch/mux"
+)
+
+// Name is the encoding the Pool is registered as for negotiation
+const Name = "cbor"
+
+func init() {
+	mux.Register(Name, new(Pool))
+}
+
+type Pool struct {
+}
+
+func (p *Pool) NewBufferEncoder() mux.BufferEncoder {
+	return NewBufferEncoder(new(bytes.Buffer))
+}
+
+func (p *Pool) NewBufferDecoder() mux.BufferDecoder {
+	return NewBufferDecoder(new(bytes.Buffer))
+}
+
+func (p *Pool) NewEncoder(w io.Writer) mux.Encoder {
+	return NewEncoder(w)
+}
+
+func (p *Pool) NewDecoder(r io.Reader) mux.Decoder {
+	return NewDecoder(r)
+}
+
+func (p *Pool) NewReceiver(ch interface{}) mux.Receiver {
+	return mux.NewReceiver(ch, p)
+}
+
+func (p *Pool) NewServer(conn net.Conn, config *mux.Config) (mux.Server, error) {
+	return NewServer(conn, config)
+}
+
+func (p *Pool) NewClient(conn net.Conn, config *mux.Config) (mux.Client, error) {
+	return NewClient(conn, config)
+}
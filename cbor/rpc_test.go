@@ -0,0 +1,15 @@
+package cbor
+
+import (
+	"testing"
+
+	"github.com/doubledutch/mux/tests"
+)
+
+func TestRPC(t *testing.T) {
+	tests.RPC(t, NewDefaultConn)
+}
+
+func TestNetRPC(t *testing.T) {
+	tests.NetRPC(t, new(Pool), NewDefaultConn)
+}
@@ -0,0 +1,268 @@
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/doubledutch/mux"
+)
+
+// Each encoded value is a chunk:
+//
+//	[flag uint8][length uvarint][payload]
+//
+// The flag tells the receiver whether the payload was compressed, so only
+// the sender needs to know the compression level. Over a Conn both sides
+// compress at the lower of their levels.
+
+const (
+	// flagStored marks a payload that wasn't compressed
+	flagStored uint8 = 0
+	// flagFlate marks a payload compressed with compress/flate
+	flagFlate uint8 = 1
+
+	// DefaultThreshold is used when the Pool doesn't specify Threshold
+	DefaultThreshold = 1024
+)
+
+var (
+	// ErrInvalidChunk is returned when decoding data that wasn't encoded by
+	// a compress Pool
+	ErrInvalidChunk = errors.New("compress: invalid chunk")
+	// ErrTooLarge is returned when a payload decompresses to more than
+	// mux.MaxFrameSize
+	ErrTooLarge = errors.New("compress: decompressed payload too large")
+)
+
+// compressor compresses payloads at or above a threshold at the level
+// returned by level, not at all while it returns zero
+type compressor struct {
+	level     func() int
+	threshold int
+
+	// w compresses at wLevel
+	w      *flate.Writer
+	wLevel int
+	buf    bytes.Buffer
+}
+
+func newCompressor(level func() int, threshold int) *compressor {
+	if threshold == 0 {
+		threshold = DefaultThreshold
+	}
+
+	return &compressor{
+		level:     level,
+		threshold: threshold,
+	}
+}
+
+// writeChunk writes p to w as a chunk, compressed if that makes it smaller
+func (c *compressor) writeChunk(w io.Writer, p []byte) error {
+	flag, payload := flagStored, p
+	if level := c.level(); len(p) >= c.threshold && level != 0 {
+		compressed, err := c.compress(p, level)
+		if err != nil {
+			return err
+		}
+		if len(compressed) < len(p) {
+			flag, payload = flagFlate, compressed
+		}
+	}
+
+	hdr := make([]byte, 1, 1+binary.MaxVarintLen64)
+	hdr[0] = flag
+	hdr = binary.AppendUvarint(hdr, uint64(len(payload)))
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// compress returns p compressed at level, valid until the next call
+func (c *compressor) compress(p []byte, level int) ([]byte, error) {
+	c.buf.Reset()
+	if c.w == nil || c.wLevel != level {
+		w, err := flate.NewWriter(&c.buf, level)
+		if err != nil {
+			return nil, err
+		}
+		c.w, c.wLevel = w, level
+	} else {
+		c.w.Reset(&c.buf)
+	}
+
+	if _, err := c.w.Write(p); err != nil {
+		return nil, err
+	}
+	if err := c.w.Close(); err != nil {
+		return nil, err
+	}
+	return c.buf.Bytes(), nil
+}
+
+// reader is what chunks are read from
+type reader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// decompressor reads chunks
+type decompressor struct {
+	r io.ReadCloser
+}
+
+// readChunk reads a chunk from r and writes its payload to w
+func (d *decompressor) readChunk(r reader, w io.Writer) error {
+	flag, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return ErrInvalidChunk
+	}
+	if n > mux.MaxFrameSize {
+		return ErrTooLarge
+	}
+	payload := io.LimitReader(r, int64(n))
+
+	switch flag {
+	case flagStored:
+		_, err = io.CopyN(w, payload, int64(n))
+	case flagFlate:
+		if d.r == nil {
+			d.r = flate.NewReader(payload)
+		} else if err := d.r.(flate.Resetter).Reset(payload, nil); err != nil {
+			return err
+		}
+		var written int64
+		written, err = io.Copy(w, io.LimitReader(d.r, mux.MaxFrameSize+1))
+		if err == nil && written > mux.MaxFrameSize {
+			err = ErrTooLarge
+		}
+		// Skip anything after the end of the flate stream
+		if err == nil {
+			_, err = io.Copy(io.Discard, payload)
+		}
+	default:
+		return fmt.Errorf("compress: unknown flag %d", flag)
+	}
+
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// BufferEncoder encodes values with a Pool's BufferEncoder and compresses
+// each one as a chunk
+type BufferEncoder struct {
+	*bytes.Buffer
+	enc mux.BufferEncoder
+	// encoded is how much of enc's buffer was already compressed
+	encoded int
+	c       *compressor
+}
+
+// Encode encodes v and appends it as a chunk
+func (e *BufferEncoder) Encode(v interface{}) error {
+	if err := e.enc.Encode(v); err != nil {
+		return err
+	}
+	b := e.enc.Bytes()[e.encoded:]
+	e.encoded += len(b)
+	return e.c.writeChunk(e.Buffer, b)
+}
+
+// Reset resets the buffer and the wrapped BufferEncoder
+func (e *BufferEncoder) Reset() {
+	e.Buffer.Reset()
+	e.enc.Reset()
+	e.encoded = 0
+}
+
+// BufferDecoder decompresses chunks and decodes them with a Pool's
+// BufferDecoder
+type BufferDecoder struct {
+	*bytes.Buffer
+	dec mux.BufferDecoder
+	d   decompressor
+}
+
+// Decode decodes the next chunk into v
+func (d *BufferDecoder) Decode(v interface{}) error {
+	if err := d.d.readChunk(d.Buffer, d.dec); err != nil {
+		return err
+	}
+	return d.dec.Decode(v)
+}
+
+// Reset resets the buffer and the wrapped BufferDecoder
+func (d *BufferDecoder) Reset() {
+	d.Buffer.Reset()
+	d.dec.Reset()
+}
+
+// ResetStream resets the wrapped BufferDecoder if it's a mux.StreamResetter
+func (d *BufferDecoder) ResetStream() {
+	mux.ResetStream(d.dec)
+}
+
+// Encoder writes values encoded with a Pool's Encoder to an io.Writer, each
+// one as a chunk
+type Encoder struct {
+	w   io.Writer
+	buf bytes.Buffer
+	enc mux.Encoder
+	c   *compressor
+}
+
+func newEncoder(w io.Writer, pool mux.Pool, c *compressor) *Encoder {
+	e := &Encoder{w: w, c: c}
+	e.enc = pool.NewEncoder(&e.buf)
+	return e
+}
+
+// Encode encodes v and writes it as a chunk
+func (e *Encoder) Encode(v interface{}) error {
+	e.buf.Reset()
+	if err := e.enc.Encode(v); err != nil {
+		return err
+	}
+	return e.c.writeChunk(e.w, e.buf.Bytes())
+}
+
+// Decoder reads chunks from an io.Reader and decodes them with a Pool's
+// Decoder
+type Decoder struct {
+	r   reader
+	buf bytes.Buffer
+	dec mux.Decoder
+	d   decompressor
+}
+
+func newDecoder(r io.Reader, pool mux.Pool) *Decoder {
+	rr, ok := r.(reader)
+	if !ok {
+		rr = bufio.NewReader(r)
+	}
+
+	d := &Decoder{r: rr}
+	d.dec = pool.NewDecoder(&d.buf)
+	return d
+}
+
+// Decode reads the next chunk into v
+func (d *Decoder) Decode(v interface{}) error {
+	if err := d.d.readChunk(d.r, &d.buf); err != nil {
+		return err
+	}
+	return d.dec.Decode(v)
+}
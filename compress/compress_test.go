@@ -0,0 +1,77 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"strings"
+	"testing"
+
+	"github.com/doubledutch/mux/json"
+)
+
+func TestThreshold(t *testing.T) {
+	pool := &Pool{Pool: new(json.Pool), Level: flate.BestCompression, Threshold: 100}
+
+	for _, tc := range []struct {
+		value string
+		flag  uint8
+	}{
+		{"short", flagStored},
+		{strings.Repeat("log line ", 100), flagFlate},
+	} {
+		enc := pool.NewBufferEncoder()
+		if err := enc.Encode(tc.value); err != nil {
+			t.Fatal(err)
+		}
+		if flag := enc.Bytes()[0]; flag != tc.flag {
+			t.Fatalf("expected flag %d, got %d", tc.flag, flag)
+		}
+		if tc.flag == flagFlate && enc.Len() >= len(tc.value) {
+			t.Fatalf("%d bytes compressed to %d", len(tc.value), enc.Len())
+		}
+
+		// Receivers don't need to know the level
+		dec := NewPool(new(json.Pool)).NewBufferDecoder()
+		dec.Write(enc.Bytes())
+		var actual string
+		if err := dec.Decode(&actual); err != nil {
+			t.Fatal(err)
+		}
+		if actual != tc.value {
+			t.Fatalf("'%s' != '%s'", actual, tc.value)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	pool := &Pool{Pool: new(json.Pool), Threshold: 1}
+
+	var buf bytes.Buffer
+	enc := pool.NewEncoder(&buf)
+	expected := []string{"hello", strings.Repeat("world ", 1000)}
+	for _, s := range expected {
+		if err := enc.Encode(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dec := pool.NewDecoder(&buf)
+	for _, s := range expected {
+		var actual string
+		if err := dec.Decode(&actual); err != nil {
+			t.Fatal(err)
+		}
+		if actual != s {
+			t.Fatalf("'%s' != '%s'", actual, s)
+		}
+	}
+}
+
+func TestInvalidChunk(t *testing.T) {
+	dec := NewPool(new(json.Pool)).NewBufferDecoder()
+	dec.Write([]byte{7, 1, 'x'})
+	var s string
+	if err := dec.Decode(&s); err == nil {
+		t.Fatal("expected error decoding an unknown flag")
+	}
+}
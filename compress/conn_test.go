@@ -0,0 +1,236 @@
+package compress
+
+import (
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/mux"
+	"github.com/doubledutch/mux/gob"
+	"github.com/doubledutch/mux/json"
+	"github.com/doubledutch/mux/tests"
+)
+
+var pools = map[string]*Pool{
+	"gob":  NewPool(new(gob.Pool)),
+	"json": NewPool(new(json.Pool)),
+	// Compress everything
+	"json-1": {Pool: new(json.Pool), Threshold: 1},
+}
+
+func newConn(pool *Pool) tests.NewConn {
+	return func(conn net.Conn) (mux.Conn, error) {
+		return mux.NewConn(conn, pool, mux.DefaultConfig())
+	}
+}
+
+func TestConnection(t *testing.T) {
+	for name, pool := range pools {
+		t.Run(name, func(t *testing.T) {
+			tests.Connection(t, pool, newConn(pool))
+		})
+	}
+}
+
+func TestSharedType(t *testing.T) {
+	for name, pool := range pools {
+		t.Run(name, func(t *testing.T) {
+			tests.SharedType(t, pool, newConn(pool))
+		})
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	for name, pool := range pools {
+		t.Run(name, func(t *testing.T) {
+			tests.Capabilities(t, func(conn net.Conn, config *mux.Config) (mux.Conn, error) {
+				return mux.NewConn(conn, pool, config)
+			})
+		})
+	}
+}
+
+func TestSendRaw(t *testing.T) {
+	for name, pool := range pools {
+		t.Run(name, func(t *testing.T) {
+			tests.SendRaw(t, newConn(pool))
+		})
+	}
+}
+
+func TestSendAfterTooLarge(t *testing.T) {
+	for name, pool := range pools {
+		t.Run(name, func(t *testing.T) {
+			tests.SendAfterTooLarge(t, pool)
+		})
+	}
+}
+
+func TestCapCompression(t *testing.T) {
+	pool := &Pool{Pool: new(json.Pool), Threshold: 1}
+	for _, tc := range []struct {
+		caps mux.Capabilities
+		flag uint8
+	}{
+		{mux.AllCapabilities, flagFlate},
+		{mux.AllCapabilities &^ mux.CapCompression, flagStored},
+	} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		peer, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		accepted, err := l.Accept()
+		l.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := mux.NewConn(accepted, pool, &mux.Config{
+			Timeout: time.Second,
+			Lager:   tests.Lager(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		go conn.Recv()
+
+		// The peer's hello, then the frame after the Conn's hello
+		hello := binary.AppendUvarint([]byte{'M', 'U', 'X', 2}, uint64(tc.caps))
+		if _, err := peer.Write(hello); err != nil {
+			t.Fatal(err)
+		}
+		conn.Capabilities()
+		r := bufio.NewReader(peer)
+		if _, err := io.ReadFull(r, make([]byte, 4)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := binary.ReadUvarint(r); err != nil {
+			t.Fatal(err)
+		}
+		// expectFlag reads the next frame and checks its chunk's flag
+		expectFlag := func(via string) {
+			header := make([]byte, 2)
+			if _, err := io.ReadFull(r, header); err != nil {
+				t.Fatal(err)
+			}
+			n, err := binary.ReadUvarint(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := make([]byte, n)
+			if _, err := io.ReadFull(r, data); err != nil {
+				t.Fatal(err)
+			}
+			if data[0] != tc.flag {
+				t.Fatalf("%s with capabilities %b expected flag %d, got %d", via, tc.caps, tc.flag, data[0])
+			}
+		}
+
+		large := strings.Repeat("hello world", 1000)
+		if err := conn.Send(mux.LogType, large); err != nil {
+			t.Fatal(err)
+		}
+		expectFlag("Send")
+
+		// Encoders from the Conn's Pool are gated too
+		enc := conn.Pool().NewBufferEncoder()
+		if err := enc.Encode(large); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.SendRaw(mux.LogType, enc.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		expectFlag("Pool")
+
+		conn.Shutdown()
+		peer.Close()
+	}
+}
+
+func TestCompressionLevel(t *testing.T) {
+	// connect returns a Conn of pool with Recv running and the peer's end
+	connect := func(pool mux.Pool, config *mux.Config) (mux.Conn, net.Conn) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		peer, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		accepted, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := mux.NewConn(accepted, pool, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go conn.Recv()
+		return conn, peer
+	}
+	level := func(conn mux.Conn) int {
+		conn.Capabilities()
+		return conn.Pool().(*Pool).compressionLevel()
+	}
+
+	for _, tc := range []struct {
+		client, server int
+		disabled       mux.Capabilities
+		expected       int
+	}{
+		{9, 1, 0, 1},
+		{1, 9, 0, 1},
+		{0, 9, 0, 6},
+		{flate.BestSpeed, 0, 0, flate.BestSpeed},
+		{flate.HuffmanOnly, 9, 0, flate.HuffmanOnly},
+		{9, 9, mux.CapCompression, 0},
+	} {
+		client, peer := connect(&Pool{Pool: new(json.Pool), Level: tc.client}, &mux.Config{
+			Timeout: time.Second,
+			Lager:   tests.Lager(),
+		})
+		server, err := mux.NewConn(peer, &Pool{Pool: new(json.Pool), Level: tc.server}, &mux.Config{
+			Timeout:             time.Second,
+			Lager:               tests.Lager(),
+			DisableCapabilities: tc.disabled,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		go server.Recv()
+
+		if actual := level(client); actual != tc.expected {
+			t.Fatalf("client at %d and server at %d expected %d, got %d", tc.client, tc.server, tc.expected, actual)
+		}
+		if actual := level(server); actual != tc.expected {
+			t.Fatalf("server at %d and client at %d expected %d, got %d", tc.server, tc.client, tc.expected, actual)
+		}
+		client.Shutdown()
+		server.Shutdown()
+	}
+
+	// A peer that offers no level leaves the Pool's own
+	conn, peer := connect(&Pool{Pool: new(json.Pool), Level: 9}, &mux.Config{
+		Timeout: time.Second,
+		Lager:   tests.Lager(),
+	})
+	defer conn.Shutdown()
+	defer peer.Close()
+	hello := binary.AppendUvarint([]byte{'M', 'U', 'X', 2}, uint64(mux.AllCapabilities))
+	if _, err := peer.Write(hello); err != nil {
+		t.Fatal(err)
+	}
+	if actual := level(conn); actual != 9 {
+		t.Fatalf("expected 9, got %d", actual)
+	}
+}
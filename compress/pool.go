@@ -0,0 +1,112 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"net"
+
+	"github.com/doubledutch/mux"
+)
+
+// Pool wraps a mux.Pool, compressing the values it encodes with
+// compress/flate. Values encoded smaller than Threshold bytes aren't
+// compressed. It's a mux.CompressingPool, so encoders from a Conn or its Pool
+// method don't compress before both sides agree on mux.CapCompression, and
+// then compress at the lower of both sides' Level. Values are always decoded,
+// compressed or not.
+type Pool struct {
+	// Pool encodes values before they're compressed
+	Pool mux.Pool
+
+	// Level is the compress/flate level, zero uses flate.DefaultCompression
+	Level int
+
+	// Threshold is the smallest encoded value that's compressed, zero uses
+	// DefaultThreshold
+	Threshold int
+
+	// level returns the level to compress at, Level when it's nil
+	level func() int
+}
+
+// NewPool creates a Pool compressing values encoded by pool
+func NewPool(pool mux.Pool) *Pool {
+	return &Pool{
+		Pool: pool,
+	}
+}
+
+func (p *Pool) NewBufferEncoder() mux.BufferEncoder {
+	return &BufferEncoder{
+		Buffer: new(bytes.Buffer),
+		enc:    p.Pool.NewBufferEncoder(),
+		c:      newCompressor(p.compressionLevel, p.Threshold),
+	}
+}
+
+func (p *Pool) NewBufferDecoder() mux.BufferDecoder {
+	return &BufferDecoder{
+		Buffer: new(bytes.Buffer),
+		dec:    p.Pool.NewBufferDecoder(),
+	}
+}
+
+func (p *Pool) NewEncoder(w io.Writer) mux.Encoder {
+	return newEncoder(w, p.Pool, newCompressor(p.compressionLevel, p.Threshold))
+}
+
+func (p *Pool) NewDecoder(r io.Reader) mux.Decoder {
+	return newDecoder(r, p.Pool)
+}
+
+// CompressionLevel implements mux.CompressingPool, returning Level or
+// flate.DefaultCompression if it's zero
+func (p *Pool) CompressionLevel() int {
+	if p.Level == 0 {
+		return flate.DefaultCompression
+	}
+	return p.Level
+}
+
+// WithCompression implements mux.CompressingPool, returning a copy of p
+// whose encoders compress at the level returned by level
+func (p *Pool) WithCompression(level func() int) mux.Pool {
+	cp := *p
+	cp.level = level
+	return &cp
+}
+
+// compressionLevel returns the level encoders compress at, zero for none
+func (p *Pool) compressionLevel() int {
+	if p.level != nil {
+		return p.level()
+	}
+	return p.CompressionLevel()
+}
+
+// NewReceiver creates a mux.Receiver that decompresses values before
+// decoding them
+func (p *Pool) NewReceiver(ch interface{}) mux.Receiver {
+	return mux.NewReceiver(ch, p)
+}
+
+// NewServer creates a mux.Server using p
+func (p *Pool) NewServer(conn net.Conn, config *mux.Config) (mux.Server, error) {
+	c, err := mux.NewConn(conn, p, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return mux.NewServer(c)
+}
+
+// NewClient creates a mux.Client using p
+func (p *Pool) NewClient(conn net.Conn, config *mux.Config) (mux.Client, error) {
+	c, err := mux.NewConn(conn, p, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return mux.NewClient(c)
+}
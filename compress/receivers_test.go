@@ -0,0 +1,23 @@
+package compress
+
+import (
+	"testing"
+
+	"github.com/doubledutch/mux/tests"
+)
+
+func TestStringReceiver(t *testing.T) {
+	for name, pool := range pools {
+		t.Run(name, func(t *testing.T) {
+			tests.StringReceiver(t, pool)
+		})
+	}
+}
+
+func TestSignalReceiver(t *testing.T) {
+	for name, pool := range pools {
+		t.Run(name, func(t *testing.T) {
+			tests.SignalReceiver(t, pool)
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package compress
+
+import (
+	"testing"
+
+	"github.com/doubledutch/mux/tests"
+)
+
+func TestRPC(t *testing.T) {
+	for name, pool := range pools {
+		t.Run(name, func(t *testing.T) {
+			tests.RPC(t, newConn(pool))
+		})
+	}
+}
+
+func TestNetRPC(t *testing.T) {
+	for name, pool := range pools {
+		t.Run(name, func(t *testing.T) {
+			tests.NetRPC(t, pool, newConn(pool))
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package compress
+
+import (
+	"net"
+	"testing"
+
+	"github.com/doubledutch/mux"
+	"github.com/doubledutch/mux/tests"
+)
+
+func newServer(pool *Pool) tests.NewServer {
+	return func(conn net.Conn) (mux.Server, error) {
+		return pool.NewServer(conn, mux.DefaultConfig())
+	}
+}
+
+func newClient(pool *Pool) tests.NewClient {
+	return func(conn net.Conn) (mux.Client, error) {
+		return pool.NewClient(conn, mux.DefaultConfig())
+	}
+}
+
+func TestHappyClientServer(t *testing.T) {
+	for name, pool := range pools {
+		t.Run(name, func(t *testing.T) {
+			tests.HappyClientServer(t, pool, newServer(pool), newClient(pool))
+		})
+	}
+}
+
+func TestClientServerErr(t *testing.T) {
+	for name, pool := range pools {
+		t.Run(name, func(t *testing.T) {
+			tests.ClientServerErr(t, pool, newServer(pool), newClient(pool))
+		})
+	}
+}
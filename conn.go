@@ -2,6 +2,7 @@ package mux
 
 // NetConn wraps net.Conn which communicates using gob
 import (
+	"context"
 	"io"
 	"net"
 	"strings"
@@ -15,16 +16,59 @@ import (
 type Conn interface {
 	// Receive registers a receiver to receive t
 	Receive(t uint8, r Receiver)
+	// Replace registers a receiver to receive t, closing the one it
+	// replaces. Stateful Pools such as gob's need SelfDescribing.
+	Replace(t uint8, r Receiver)
+	// Unregister stops receiving t and closes its receiver
+	Unregister(t uint8)
+	// Receivers returns the registered receivers by type
+	Receivers() map[uint8]Receiver
 	// Send encodes a frame on conn using t and e
 	Send(t uint8, e interface{}) error
+	// SendContext is Send that gives up once ctx is done
+	SendContext(ctx context.Context, t uint8, e interface{}) error
+	// SendAsync queues a frame and returns a channel receiving the result of
+	// writing it
+	SendAsync(t uint8, e interface{}) <-chan error
+	// SendRaw sends b as the data of a frame using t, without encoding it
+	SendRaw(t uint8, b []byte) error
+	// SendStream sends the contents of r on t in chunks, for a
+	// ReaderReceiver
+	SendStream(t uint8, r io.Reader) error
+	// SendReliable sends a frame of a reliable type, returning the Delivery
+	// that tracks its acknowledgement
+	SendReliable(ctx context.Context, t uint8, e interface{}) (*Delivery, error)
 	// Recv listens for frames and sends them to a receiver
 	Recv()
-	// Pool returns the pool used by the Conn
+	// RecvContext is Recv that returns once ctx is done
+	RecvContext(ctx context.Context) error
+	// Pool returns the pool used by the Conn, a CompressingPool's encoders
+	// only compress once both sides support CapCompression
 	Pool() Pool
 	// Shutdown closes the gob connection
 	Shutdown()
+	// ShutdownContext gracefully shuts down the connection, giving up on
+	// draining once ctx is done
+	ShutdownContext(ctx context.Context) error
 	// IsShutdown provides a way to listen for this connection to shutdown
 	IsShutdown() chan struct{}
+	// OpenStream opens a new logical stream to the peer
+	OpenStream() (Stream, error)
+	// AcceptStream waits for and returns the next stream opened by the peer
+	AcceptStream() (Stream, error)
+	// RTT returns the latest and smoothed round-trip time of heartbeats
+	RTT() (last, smoothed time.Duration)
+	// Err returns the error the connection was shutdown with, if any
+	Err() error
+	// GoAway gracefully shuts down the connection, telling the peer why
+	GoAway(code CloseCode, message string) error
+	// CloseReason returns why the connection was closed
+	CloseReason() CloseReason
+	// Capabilities waits for the peer's hello and returns the capabilities
+	// both sides support
+	Capabilities() Capabilities
+	// SchemaDrift returns the frame types the peer declared differently
+	SchemaDrift() []uint8
 }
 
 // Receiver defines an interface for receiving
@@ -38,20 +82,54 @@ type conn struct {
 	// store the net.Conn to SetDeadlines
 	conn net.Conn
 
-	// used to encode data into frames
-	sendEnc  BufferEncoder
+	// used to encode data into frames, one encoder per frame type like the
+	// receivers so stateful encodings stay in step with their decoder
+	sendEncs map[uint8]*sendEncoder
 	sendLock sync.Mutex
 
-	// encode and decode conn
-	enc Encoder
-	dec Decoder
+	// write and read frames on conn, fw is only used by the writer goroutine
+	fw *frameWriter
+	fr *frameReader
+
+	// Frames waiting for the writer
+	control       chan *outFrame
+	queue         chan *outFrame
+	streamQueue   chan *outFrame
+	fragmentQueue chan *outFrame
+	sendPolicy    SendPolicy
+
+	// Messages split into fragments and those being reassembled
+	fragments *fragments
 
 	// Store receivers for Frames
-	Receivers map[uint8]Receiver
+	receivers *registry
+
+	// Frames of reliable types waiting for acknowledgement and those received
+	reliable       *Reliable
+	redeliverStart sync.Once
+
+	// Logical streams multiplexed on StreamType
+	streams *streams
+
+	// Pings sent on PingType
+	heartbeat *heartbeat
+
+	// Graceful shutdown using GoAwayType
+	goAway *goAway
+
+	// Capabilities exchanged in the hello
+	hello *hello
+
+	// Declared frame types and those the peer declared differently
+	schema    *Schema
+	drift     []uint8
+	driftLock sync.Mutex
 
 	// allow of users and ourselves to listen for shutdown
-	ShutdownCh chan struct{}
-	isShutdown bool
+	ShutdownCh   chan struct{}
+	isShutdown   bool
+	shutdownErr  error
+	shutdownLock sync.Mutex
 
 	// timeout for receiving frames
 	timeout time.Duration
@@ -63,8 +141,18 @@ type conn struct {
 
 // Frame represents transport
 type Frame struct {
-	Type uint8
-	Data []byte
+	Type  uint8
+	Flags uint8
+	Data  []byte
+}
+
+// sendEncoder encodes the frames of a type, its lock keeps them in order
+type sendEncoder struct {
+	sync.Mutex
+	enc BufferEncoder
+	// reset is set once enc was replaced, until a frame flagged with
+	// flagReset is queued
+	reset bool
 }
 
 // NewConn creates a new NetConn using the specified conn and config
@@ -73,82 +161,314 @@ func NewConn(netConn net.Conn, pool Pool, config *Config) (Conn, error) {
 		return nil, err
 	}
 
-	return &conn{
+	queueSize := config.SendQueueSize
+	if queueSize == 0 {
+		queueSize = DefaultSendQueueSize
+	}
+
+	reliable := config.Reliable
+	if reliable == nil {
+		reliable = NewReliable(0)
+	}
+
+	h := newHello(config, pool)
+	c := &conn{
 		conn: netConn,
 
-		sendEnc:  pool.NewBufferEncoder(),
+		sendEncs: make(map[uint8]*sendEncoder),
 		sendLock: sync.Mutex{},
 
-		dec: pool.NewDecoder(netConn),
-		enc: pool.NewEncoder(netConn),
+		fw: newFrameWriter(netConn, h.offered),
+		fr: newFrameReader(netConn),
+
+		control:       make(chan *outFrame, controlQueueSize),
+		queue:         make(chan *outFrame, queueSize),
+		streamQueue:   make(chan *outFrame, queueSize),
+		fragmentQueue: make(chan *outFrame, fragmentQueueSize),
+		sendPolicy:    config.SendPolicy,
+		fragments:     newFragments(config.FragmentSize, config.MaxMessageSize),
 
-		Receivers:  make(map[uint8]Receiver),
+		receivers:  newRegistry(),
+		reliable:   reliable,
+		streams:    newStreams(config.StreamWindow),
+		heartbeat:  newHeartbeat(config.PingInterval, config.MaxMissedPings),
+		goAway:     newGoAway(config.DrainTimeout),
+		hello:      h,
+		schema:     config.Schema,
 		ShutdownCh: make(chan struct{}),
 
 		timeout: config.Timeout,
 		lgr:     config.Lager,
 		pool:    pool,
-	}, nil
+	}
+	if cp, ok := pool.(CompressingPool); ok {
+		c.pool = cp.WithCompression(c.compressionLevel)
+	}
+	go c.writer()
+
+	return c, nil
 }
 
-// Send encodes a frame on conn using t and e
+// Send encodes a frame on conn using t and e. Values encoded larger than the
+// Config's MaxMessageSize fail with ErrMessageTooLarge.
 func (c *conn) Send(t uint8, e interface{}) error {
-	// Single threaded through here
+	return c.SendContext(context.Background(), t, e)
+}
+
+// SendContext encodes a frame on conn using t and e, giving up once ctx is
+// done. Since a frame can't be left half written, the connection is shutdown
+// if ctx is done while the frame is being written.
+func (c *conn) SendContext(ctx context.Context, t uint8, e interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f, _, err := c.queueSend(ctx, t, e)
+	if err != nil {
+		return err
+	}
+	return c.wait(ctx, f)
+}
+
+// SendAsync encodes a frame on conn using t and e without waiting for it to
+// be written. The returned channel receives the result of writing it.
+// Messages sent as fragments are written before SendAsync returns.
+func (c *conn) SendAsync(t uint8, e interface{}) <-chan error {
+	f, _, err := c.queueSend(context.Background(), t, e)
+	if err != nil {
+		done := make(chan error, 1)
+		done <- err
+		return done
+	}
+	return f.done
+}
+
+// SendRaw sends b as the data of a frame using t, bypassing the Pool. b is
+// written as is, so it must not be modified until SendRaw returns.
+func (c *conn) SendRaw(t uint8, b []byte) error {
+	if err := c.checkDrift(t); err != nil {
+		return err
+	}
+
+	enc := c.sendEncoder(t)
+	enc.Lock()
+	f, err := c.queueRaw(t, b)
+	enc.Unlock()
+	if err != nil {
+		return err
+	}
+	return c.wait(context.Background(), f)
+}
+
+// queueRaw queues b for the writer as the data of a frame using t, the caller
+// must hold t's sendEncoder
+func (c *conn) queueRaw(t uint8, b []byte) (*outFrame, error) {
+	if !c.goAway.beginSend() {
+		return nil, ErrGoingAway
+	}
+	return c.queueMessage(context.Background(), t, 0, b, false)
+}
+
+// queueSend encodes e and queues it for the writer, frames of reliable types
+// are tracked by the returned Delivery
+func (c *conn) queueSend(ctx context.Context, t uint8, e interface{}) (*outFrame, *Delivery, error) {
+	if err := c.checkSend(t, e); err != nil {
+		return nil, nil, err
+	}
+	reliable := c.reliable.has(t)
+	if reliable {
+		if err := c.checkReliable(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+	if !c.goAway.beginSend() {
+		return nil, nil, ErrGoingAway
+	}
+
+	// Single threaded per type through here, frames of a type are queued in
+	// the order they were encoded in
+	enc := c.sendEncoder(t)
+	enc.Lock()
+	defer enc.Unlock()
+
+	if enc.enc == nil {
+		enc.enc = c.pool.NewBufferEncoder()
+	}
+	defer enc.enc.Reset()
+
+	var flags uint8
+	if enc.reset {
+		flags = flagReset
+	}
+	if err := enc.enc.Encode(e); err != nil {
+		c.goAway.endSend()
+		enc.replace()
+		return nil, nil, err
+	}
+	if !reliable {
+		f, err := c.queueMessage(ctx, t, flags, enc.enc.Bytes(), true)
+		if err != nil {
+			enc.replace()
+			return nil, nil, err
+		}
+		enc.reset = false
+		return f, nil, nil
+	}
+
+	d := c.reliable.track(t, enc.enc.Bytes())
+	f, err := c.queueMessage(ctx, t, flags|flagReliable, d.data, false)
+	if err != nil {
+		c.reliable.forget(d)
+		enc.replace()
+		return nil, nil, err
+	}
+	enc.reset = false
+	return f, d, nil
+}
+
+// replace drops the encoder once a frame it encoded can't be sent, since a
+// stateful one like gob's expects the peer to have decoded it. The next
+// frame comes from a new encoder and tells the peer to reset its decoder.
+func (enc *sendEncoder) replace() {
+	enc.enc = nil
+	enc.reset = true
+}
+
+// sendEncoder returns the sendEncoder of t
+func (c *conn) sendEncoder(t uint8) *sendEncoder {
 	c.sendLock.Lock()
-	c.sendEnc.Encode(e)
+	defer c.sendLock.Unlock()
 
-	d := make([]byte, c.sendEnc.Len())
-	copy(d, c.sendEnc.Bytes())
-	c.sendEnc.Reset()
-	c.sendLock.Unlock()
+	enc, ok := c.sendEncs[t]
+	if !ok {
+		enc = new(sendEncoder)
+		c.sendEncs[t] = enc
+	}
+	return enc
+}
 
-	f := Frame{
-		Type: t,
-		Data: d,
+// queueMessage queues d for the writer as a message with flags sent by Send,
+// split into fragments when it's too large. Otherwise d is copied if copyData
+// is set. The caller must hold t's sendEncoder and have called beginSend.
+func (c *conn) queueMessage(ctx context.Context, t, flags uint8, d []byte, copyData bool) (*outFrame, error) {
+	fragment, err := c.fragmenting(ctx, len(d))
+	if err != nil {
+		c.goAway.endSend()
+		return nil, err
+	}
+	if fragment {
+		return c.queueFragments(ctx, t, flags, d)
 	}
-	c.lgr.Debugf("Sending frame: %v\n", f)
 
-	return c.enc.Encode(f)
+	if copyData {
+		d = append(make([]byte, 0, len(d)), d...)
+	}
+	return c.queueData(ctx, t, flags, d)
 }
 
-// Receive registers a receiver to receive t
-func (c *conn) Receive(t uint8, r Receiver) {
-	c.Receivers[t] = r
-	c.lgr.Debugf("Added receiver type %d\n", t)
+// queueData queues d for the writer as a frame with flags sent by Send, the
+// caller must hold t's sendEncoder and have called beginSend
+func (c *conn) queueData(ctx context.Context, t, flags uint8, d []byte) (*outFrame, error) {
+	f := newOutFrame(ctx, Frame{
+		Type:  t,
+		Flags: flags,
+		Data:  d,
+	})
+	f.sent = true
+	if err := c.enqueueSend(ctx, f); err != nil {
+		c.goAway.endSend()
+		return nil, err
+	}
+	return f, nil
 }
 
 // Recv listens for frames and sends them to a receiver
 func (c *conn) Recv() {
+	c.RecvContext(context.Background())
+}
+
+// RecvContext listens for frames and sends them to a receiver until ctx is
+// done, returning ctx.Err(). Otherwise it returns the error the connection
+// was shutdown with.
+func (c *conn) RecvContext(ctx context.Context) error {
+	c.startHeartbeat()
+	c.startRedelivery()
+
+	// Wake up the decoder as soon as ctx is done
+	stop := afterDone(ctx, c.conn.SetReadDeadline)
+	defer stop()
+
 	for {
-		var frame Frame
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		c.conn.SetReadDeadline(time.Now().Add(c.timeout))
-		err := c.dec.Decode(&frame)
+		frame, err := c.readFrame()
 		if err != nil {
 			if err == io.EOF || strings.Contains(err.Error(), "closed") || strings.Contains(err.Error(), "reset by peer") {
 				// This is the expected way for us to return
 				c.lgr.Debugf("Recv loop disconnected from %s", c.conn.RemoteAddr())
-				return
+				// A no-op unless the peer went away without a GOAWAY
+				c.shutdown(ErrConnLost)
+				return c.Err()
 			}
-			if err, ok := err.(*net.OpError); ok && err.Timeout() {
+			if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
 				select {
 				case <-c.ShutdownCh:
-					return
+					return c.Err()
 				default: // Keep listening
 					continue
 				}
 			} else {
-				c.lgr.Errorf("Unexpected net.OpError: %s", err)
-				return
+				c.lgr.Errorf("Unexpected error: %s", err)
+				c.shutdown(err)
+				return err
 			}
 		}
 		c.lgr.Debugf("Received frame: %v\n", frame)
-		r, ok := c.Receivers[frame.Type]
+		switch frame.Type {
+		case StreamType:
+			c.recvStream(frame.Data)
+			continue
+		case PingType:
+			c.recvPing(frame.Data)
+			continue
+		case GoAwayType:
+			c.recvGoAway(frame.Data)
+			continue
+		case SchemaType:
+			c.recvSchema(frame.Data)
+			continue
+		case AckType:
+			c.recvAck(frame.Data)
+			continue
+		}
+		data, ok := c.reassemble(frame)
+		if !ok {
+			continue
+		}
+		frame.Data = data
+		if c.schema != nil && c.checkDrift(frame.Type) != nil {
+			c.lgr.Warnf("dropping frame %d, the peer declared it differently\n", frame.Type)
+			continue
+		}
+		r, ok := c.receivers.get(frame.Type)
 		if !ok {
 			c.lgr.Warnf("dropping frame %d\n", frame.Type)
 			continue
 		}
+		if frame.Flags&flagReset != 0 {
+			ResetStream(r.r)
+		}
+		if frame.Flags&flagReliable != 0 {
+			c.recvReliable(frame, r)
+			continue
+		}
 		err = r.Receive(frame.Data)
-		if err != nil {
+		if err == errUnregistered {
+			c.lgr.Warnf("dropping frame %d, its receiver was unregistered\n", frame.Type)
+		} else if err != nil {
 			c.lgr.Errorf("Receive error %s while receiving %s", err, frame.Data)
 		}
 	}
@@ -159,26 +479,47 @@ func (c *conn) IsShutdown() chan struct{} {
 	return c.ShutdownCh
 }
 
-// Pool returns the pool used by the Conn
+// Pool returns the pool used by the Conn, a CompressingPool's encoders only
+// compress once both sides support CapCompression, at the level agreed on
 func (c *conn) Pool() Pool {
 	return c.pool
 }
 
 // Shutdown closes the gob connection
 func (c *conn) Shutdown() {
+	c.shutdown(nil)
+}
+
+// Err returns the error the connection was shutdown with, if any
+func (c *conn) Err() error {
+	c.shutdownLock.Lock()
+	defer c.shutdownLock.Unlock()
+	return c.shutdownErr
+}
+
+// shutdown closes the connection, recording err as the reason
+func (c *conn) shutdown(err error) {
+	c.shutdownLock.Lock()
 	if c.isShutdown {
+		c.shutdownLock.Unlock()
 		return
 	}
 	c.lgr.Infof("Shutting down")
 	c.isShutdown = true
+	c.shutdownErr = err
 	// Notify that we're shutdown
 	close(c.ShutdownCh)
 
-	// Let receivers clean themselves up
-	for _, h := range c.Receivers {
-		h.Close()
-	}
+	// Wake up anyone using a stream
+	c.closeStreams()
+
+	// Half received messages won't be completed
+	c.fragments.reset()
 
 	// We're done with conn
 	c.conn.Close()
+	c.shutdownLock.Unlock()
+
+	// Let receivers clean themselves up, they may call back into the Conn
+	c.receivers.closeAll()
 }
@@ -0,0 +1,31 @@
+package mux
+
+import (
+	"context"
+	"time"
+)
+
+// aLongTimeAgo is a deadline that has already passed
+var aLongTimeAgo = time.Unix(1, 0)
+
+// afterDone calls setDeadline with a deadline in the past once ctx is done,
+// waking up any I/O blocked on it. The returned stop func must be called
+// before the deadline is changed again, once it returns setDeadline won't be
+// called.
+func afterDone(ctx context.Context, setDeadline func(time.Time) error) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopFunc := context.AfterFunc(ctx, func() {
+		setDeadline(aLongTimeAgo)
+		close(done)
+	})
+
+	return func() {
+		if !stopFunc() {
+			<-done
+		}
+	}
+}
@@ -35,6 +35,25 @@ type BufferDecoder interface {
 	Decoder
 }
 
+// flagReset marks the first frame of a type from a new encoder, sent once a
+// frame encoded by the previous one couldn't be sent
+const flagReset uint8 = 1 << 4
+
+// StreamResetter is implemented by BufferDecoders carrying state from one
+// frame to the next, such as gob's, and the Receivers using them. A Conn
+// starts a new encoder for a type when a frame it encoded can't be sent, the
+// peer's Receiver then has ResetStream called before decoding the next frame.
+type StreamResetter interface {
+	ResetStream()
+}
+
+// ResetStream calls ResetStream on v if it's a StreamResetter
+func ResetStream(v interface{}) {
+	if s, ok := v.(StreamResetter); ok {
+		s.ResetStream()
+	}
+}
+
 // Pool is an interface for interacting with encoding implementations
 type Pool interface {
 	NewBufferEncoder() BufferEncoder
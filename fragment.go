@@ -0,0 +1,198 @@
+package mux
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Messages larger than the fragment size are sent as fragments, frames of
+// their type flagged with flagMore except the last. A message's fragments
+// are queued in order on their own queue and never dropped, so the writer
+// interleaves them with other frames. Recv reassembles them before calling
+// the Receiver.
+
+const (
+	// flagMore marks a fragment followed by more of the same message
+	flagMore uint8 = 1 << 1
+	// flagAbort tells the peer to drop the fragments of a message given up on
+	flagAbort uint8 = 1 << 2
+
+	// DefaultFragmentSize is used when the Config doesn't specify
+	// FragmentSize
+	DefaultFragmentSize = 64 * 1024
+	// DefaultMaxMessageSize is used when the Config doesn't specify
+	// MaxMessageSize, it allows messages several frames long
+	DefaultMaxMessageSize = 4 * MaxFrameSize
+
+	// fragmentQueueSize is the number of fragments that can be queued
+	fragmentQueueSize = 4
+)
+
+var (
+	// ErrMessageTooLarge is returned when sending a message larger than the
+	// Config's MaxMessageSize, DefaultMaxMessageSize unless set
+	ErrMessageTooLarge = errors.New("Message larger than Config MaxMessageSize")
+	// ErrInvalidFragmentSize defines an error for an invalid Config
+	// FragmentSize value
+	ErrInvalidFragmentSize = errors.New("Invalid Config FragmentSize")
+	// ErrInvalidMaxMessageSize defines an error for an invalid Config
+	// MaxMessageSize value
+	ErrInvalidMaxMessageSize = errors.New("Invalid Config MaxMessageSize")
+)
+
+// fragments holds the messages being reassembled, one per frame type
+type fragments struct {
+	size    int
+	maxSize int
+
+	lock    sync.Mutex
+	partial map[uint8]*partialMessage
+}
+
+// partialMessage is a message missing fragments, dropped once it's too large
+type partialMessage struct {
+	data    []byte
+	dropped bool
+}
+
+func newFragments(size, maxSize int) *fragments {
+	if size == 0 {
+		size = DefaultFragmentSize
+	}
+	if maxSize == 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+	return &fragments{
+		size:    size,
+		maxSize: maxSize,
+		partial: make(map[uint8]*partialMessage),
+	}
+}
+
+// reset drops the messages being reassembled
+func (fs *fragments) reset() {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	fs.partial = make(map[uint8]*partialMessage)
+}
+
+// fragmenting returns whether a message of size bytes is sent as fragments,
+// which requires the peer to support CapFragments
+func (c *conn) fragmenting(ctx context.Context, size int) (bool, error) {
+	if size > c.fragments.maxSize {
+		return false, ErrMessageTooLarge
+	}
+	if size <= c.fragments.size {
+		return false, nil
+	}
+
+	caps, ok := c.negotiated()
+	if !ok && size > MaxFrameSize {
+		// Too large for a single frame, find out whether the peer reassembles
+		var err error
+		if caps, err = c.waitHello(ctx); err != nil {
+			return false, err
+		}
+		ok = true
+	}
+	if ok && caps.Has(CapFragments) {
+		return true, nil
+	}
+	if size > MaxFrameSize {
+		return false, ErrFrameTooLarge
+	}
+	return false, nil
+}
+
+// queueFragments queues d as the fragments of a message with flags on t and
+// waits for them to be written, since they refer to d. If ctx is done part
+// way the peer is told to drop the fragments it got. The returned frame is
+// complete.
+func (c *conn) queueFragments(ctx context.Context, t, flags uint8, d []byte) (*outFrame, error) {
+	var last *outFrame
+	var err error
+	for len(d) > 0 {
+		f := newOutFrame(context.Background(), Frame{
+			Type:  t,
+			Flags: flags | flagMore,
+		})
+		n := c.fragments.size
+		if n >= len(d) {
+			n = len(d)
+			f.frame.Flags = flags
+			f.sent = true
+		}
+		f.frame.Data = d[:n]
+		if err = c.enqueue(ctx, c.fragmentQueue, f); err != nil {
+			break
+		}
+		last = f
+		d = d[n:]
+	}
+
+	if err != nil {
+		c.goAway.endSend()
+		if last != nil {
+			abort := newOutFrame(context.Background(), Frame{
+				Type:  t,
+				Flags: flagAbort,
+			})
+			if c.enqueue(context.Background(), c.fragmentQueue, abort) == nil {
+				last = abort
+			}
+		}
+	}
+	if last != nil {
+		if werr := c.wait(context.Background(), last); err == nil {
+			err = werr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f := newOutFrame(ctx, Frame{Type: t})
+	f.done <- nil
+	return f, nil
+}
+
+// reassemble adds f to the message of its type, returning the message once
+// it's complete. Messages larger than MaxMessageSize are dropped.
+func (c *conn) reassemble(f Frame) ([]byte, bool) {
+	fs := c.fragments
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	p, ok := fs.partial[f.Type]
+	switch {
+	case f.Flags&flagAbort != 0:
+		delete(fs.partial, f.Type)
+		return nil, false
+	case !ok && f.Flags&flagMore == 0:
+		// A whole message
+		if len(f.Data) > fs.maxSize {
+			c.lgr.Warnf("dropping message %d larger than %d bytes\n", f.Type, fs.maxSize)
+			return nil, false
+		}
+		return f.Data, true
+	case !ok:
+		p = new(partialMessage)
+		fs.partial[f.Type] = p
+	}
+
+	if !p.dropped && len(p.data)+len(f.Data) > fs.maxSize {
+		c.lgr.Warnf("dropping message %d larger than %d bytes\n", f.Type, fs.maxSize)
+		p.dropped = true
+		p.data = nil
+	}
+	if !p.dropped {
+		p.data = append(p.data, f.Data...)
+	}
+
+	if f.Flags&flagMore != 0 {
+		return nil, false
+	}
+	delete(fs.partial, f.Type)
+	return p.data, !p.dropped
+}
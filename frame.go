@@ -0,0 +1,268 @@
+package mux
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Wire format
+//
+// Each side starts by writing a hello, the magic "MUX" followed by the
+// protocol version and the capabilities it supports:
+//
+//	["MUX"][version uint8][capabilities uvarint]
+//
+// Version 1 peers only send the magic and version, and shut down with
+// ErrUnsupportedVersion on any other. Since version 2 hellos of later
+// versions are read as ours, so they must keep this layout. A Conn offering
+// exactly the capabilities of version 1 sends a version 1 hello, which lets
+// version 1 peers be upgraded one at a time, otherwise they must all be
+// upgraded at once. Frames follow back to back:
+//
+//	[flags uint8][type uvarint][length uvarint][data]
+//
+// Only the data is encoded using the Pool, the header is written directly.
+// Flags are reserved for frame options and are zero otherwise.
+
+const (
+	// frameVersion is the version of the wire format
+	frameVersion uint8 = 2
+	// legacyVersion is the first version, whose hello has no capabilities
+	legacyVersion uint8 = 1
+
+	// helloLen is the length of the magic and version
+	helloLen = 4
+	// maxHelloLen is the length of a hello with the longest capabilities
+	maxHelloLen = helloLen + binary.MaxVarintLen64
+
+	// maxFrameHeaderLen is the length of a flags byte and two uvarints
+	maxFrameHeaderLen = 1 + 2*binary.MaxVarintLen64
+
+	// MaxFrameSize is the largest frame data accepted
+	MaxFrameSize = 64 * 1024 * 1024
+
+	// maxWriteBuffer is the largest write buffer kept between frames
+	maxWriteBuffer = 64 * 1024
+)
+
+var (
+	// magic starts the hello in each direction
+	magic = []byte{'M', 'U', 'X'}
+
+	// ErrInvalidPreamble is the error a Conn shuts down with when the peer
+	// doesn't speak the mux wire format
+	ErrInvalidPreamble = errors.New("Invalid preamble")
+	// ErrUnsupportedVersion is the error a Conn shuts down with when the peer
+	// uses a different version of the wire format
+	ErrUnsupportedVersion = errors.New("Unsupported version")
+	// ErrInvalidFrameHeader is the error a Conn shuts down with when a frame
+	// header can't be parsed
+	ErrInvalidFrameHeader = errors.New("Invalid frame header")
+	// ErrFrameTooLarge is returned when frame data exceeds MaxFrameSize
+	ErrFrameTooLarge = errors.New("Frame too large")
+
+	// errShortHeader means more bytes are needed to parse a frame header
+	errShortHeader = errors.New("Short frame header")
+)
+
+// appendFrame appends f in wire format to b
+func appendFrame(b []byte, f Frame) []byte {
+	b = append(b, f.Flags)
+	b = binary.AppendUvarint(b, uint64(f.Type))
+	b = binary.AppendUvarint(b, uint64(len(f.Data)))
+	return append(b, f.Data...)
+}
+
+// frameWriter writes frames to w, starting with a hello offering caps
+type frameWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func newFrameWriter(w io.Writer, caps Capabilities) *frameWriter {
+	buf := make([]byte, 0, 4096)
+	buf = append(buf, magic...)
+	if caps == legacyCapabilities {
+		// Version 1 peers can read it
+		return &frameWriter{
+			w:   w,
+			buf: append(buf, legacyVersion),
+		}
+	}
+	buf = append(buf, frameVersion)
+	return &frameWriter{
+		w:   w,
+		buf: binary.AppendUvarint(buf, uint64(caps)),
+	}
+}
+
+// flush writes the hello if it wasn't written yet
+func (fw *frameWriter) flush() error {
+	if len(fw.buf) == 0 {
+		return nil
+	}
+	_, err := fw.w.Write(fw.buf)
+	fw.buf = fw.buf[:0]
+	return err
+}
+
+// write writes f in a single Write. Once anything was written the hello is
+// never resent, even if the write failed.
+func (fw *frameWriter) write(f Frame) error {
+	fw.buf = appendFrame(fw.buf, f)
+	_, err := fw.w.Write(fw.buf)
+
+	if cap(fw.buf) > maxWriteBuffer {
+		fw.buf = nil
+	}
+	fw.buf = fw.buf[:0]
+	return err
+}
+
+// frameReader reads frames from r. A read that fails, e.g. with a timeout,
+// can be retried and picks up where it left off.
+type frameReader struct {
+	r *bufio.Reader
+
+	// the frame being read once its header was parsed
+	pending bool
+	frame   Frame
+	n       int
+}
+
+func newFrameReader(r io.Reader) *frameReader {
+	return &frameReader{
+		r: bufio.NewReader(r),
+	}
+}
+
+// next returns the next frame, the hello must have been read
+func (fr *frameReader) next() (Frame, error) {
+	if !fr.pending {
+		if err := fr.readHeader(); err != nil {
+			return Frame{}, err
+		}
+		fr.pending = true
+	}
+
+	for fr.n < len(fr.frame.Data) {
+		n, err := fr.r.Read(fr.frame.Data[fr.n:])
+		fr.n += n
+		if err != nil {
+			if err == io.EOF && fr.n > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return Frame{}, err
+		}
+	}
+
+	f := fr.frame
+	fr.pending = false
+	fr.frame = Frame{}
+	fr.n = 0
+	return f, nil
+}
+
+// readHello reads the peer's hello, returning the capabilities it supports.
+// It's only consumed once it's complete.
+func (fr *frameReader) readHello() (Capabilities, error) {
+	b, err := fr.r.Peek(helloLen)
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(b[:len(magic)], magic) {
+		return 0, ErrInvalidPreamble
+	}
+
+	switch version := b[len(magic)]; {
+	case version < legacyVersion:
+		return 0, ErrUnsupportedVersion
+	case version == legacyVersion:
+		_, err = fr.r.Discard(helloLen)
+		return legacyCapabilities, err
+	}
+
+	// Newer versions are spoken as ours
+	size := helloLen + 1
+	for {
+		b, err := fr.r.Peek(size)
+		if err != nil {
+			return 0, err
+		}
+		caps, n := binary.Uvarint(b[helloLen:])
+		if n == 0 {
+			size = max(size+1, min(fr.r.Buffered(), maxHelloLen))
+			continue
+		}
+		if n < 0 {
+			return 0, ErrInvalidPreamble
+		}
+		_, err = fr.r.Discard(helloLen + n)
+		return Capabilities(caps), err
+	}
+}
+
+// readHeader parses a frame header, only consuming it once it's complete
+func (fr *frameReader) readHeader() error {
+	size := 1
+	for {
+		b, err := fr.r.Peek(size)
+		if err != nil {
+			return err
+		}
+
+		f, length, n, err := parseFrameHeader(b)
+		if err == errShortHeader {
+			// Everything buffered may already be enough
+			size = max(size+1, min(fr.r.Buffered(), maxFrameHeaderLen))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if length > MaxFrameSize {
+			return ErrFrameTooLarge
+		}
+
+		if _, err := fr.r.Discard(n); err != nil {
+			return err
+		}
+		f.Data = make([]byte, length)
+		fr.frame = f
+		return nil
+	}
+}
+
+// parseFrameHeader parses the header at the start of b, returning the frame
+// without its data, the data length and the length of the header
+func parseFrameHeader(b []byte) (Frame, uint64, int, error) {
+	if len(b) < 1 {
+		return Frame{}, 0, 0, errShortHeader
+	}
+	f := Frame{Flags: b[0]}
+	n := 1
+
+	t, tn := binary.Uvarint(b[n:])
+	if tn == 0 {
+		return Frame{}, 0, 0, errShortHeader
+	}
+	if tn < 0 || t > 255 {
+		return Frame{}, 0, 0, ErrInvalidFrameHeader
+	}
+	f.Type = uint8(t)
+	n += tn
+
+	length, ln := binary.Uvarint(b[n:])
+	if ln == 0 {
+		return Frame{}, 0, 0, errShortHeader
+	}
+	if ln < 0 {
+		return Frame{}, 0, 0, ErrInvalidFrameHeader
+	}
+	n += ln
+
+	return f, length, n, nil
+}
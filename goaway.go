@@ -0,0 +1,258 @@
+package mux
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// goAwayHeaderLen is the length of the flag and code preceding the message
+	goAwayHeaderLen = 5
+	// goAwayFlag and goAwayAckFlag mark the first byte of a GOAWAY frame
+	goAwayFlag    uint8 = 0
+	goAwayAckFlag uint8 = 1
+
+	// DefaultDrainTimeout is used when the Config doesn't specify
+	// DrainTimeout
+	DefaultDrainTimeout = 5 * time.Second
+)
+
+var (
+	// ErrGoingAway is returned by Send once the connection is going away
+	ErrGoingAway = errors.New("Connection going away")
+	// ErrDrainTimeout is returned by GoAway when the peer doesn't finish
+	// draining in time
+	ErrDrainTimeout = errors.New("Drain timeout")
+	// ErrConnLost is the error a Conn shuts down with when the peer
+	// disconnects without a GOAWAY
+	ErrConnLost = errors.New("Connection lost")
+)
+
+// CloseCode describes why a connection was closed
+type CloseCode uint32
+
+const (
+	// CloseNone means the connection hasn't been closed
+	CloseNone CloseCode = iota
+	// CloseNormal is a clean shutdown
+	CloseNormal
+	// CloseGoingAway means the side closing is going away, e.g. restarting
+	CloseGoingAway
+	// CloseProtocolError means the side closing received something invalid
+	CloseProtocolError
+	// CloseAbnormal means the connection ended without a GOAWAY, e.g. the
+	// peer crashed or stopped answering heartbeats
+	CloseAbnormal
+)
+
+// CloseReason is why a connection was closed
+type CloseReason struct {
+	Code    CloseCode
+	Message string
+	// Remote is true when the reason was sent by the peer in a GOAWAY
+	Remote bool
+}
+
+// goAway tracks a graceful shutdown
+type goAway struct {
+	lock    sync.Mutex
+	going   bool
+	reason  CloseReason
+	sending sync.WaitGroup
+
+	ack     chan struct{}
+	ackOnce sync.Once
+
+	drainTimeout time.Duration
+}
+
+func newGoAway(drainTimeout time.Duration) *goAway {
+	if drainTimeout == 0 {
+		drainTimeout = DefaultDrainTimeout
+	}
+
+	return &goAway{
+		ack:          make(chan struct{}),
+		drainTimeout: drainTimeout,
+	}
+}
+
+// beginSend tracks a Send, returning false once going away. endSend must be
+// called when it returns true.
+func (g *goAway) beginSend() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.going {
+		return false
+	}
+	g.sending.Add(1)
+	return true
+}
+
+func (g *goAway) endSend() {
+	g.sending.Done()
+}
+
+// start stops new Sends and records reason, returning false if already going
+// away
+func (g *goAway) start(reason CloseReason) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.going {
+		return false
+	}
+	g.going = true
+	g.reason = reason
+	return true
+}
+
+// goAwayFrame creates a GOAWAY frame, or its ack
+func goAwayFrame(flag uint8, reason CloseReason) Frame {
+	b := make([]byte, goAwayHeaderLen+len(reason.Message))
+	b[0] = flag
+	binary.BigEndian.PutUint32(b[1:], uint32(reason.Code))
+	copy(b[goAwayHeaderLen:], reason.Message)
+
+	return Frame{
+		Type: GoAwayType,
+		Data: b,
+	}
+}
+
+// GoAway gracefully shuts down the connection. Sends fail with ErrGoingAway
+// from now on, then the peer is sent a GOAWAY with code and message once
+// Sends in progress finish. Frames the peer sent before it received the
+// GOAWAY are still delivered until it acknowledges, or the Config's
+// DrainTimeout passes and ErrDrainTimeout is returned.
+func (c *conn) GoAway(code CloseCode, message string) error {
+	ctx, cancel := context.WithTimeoutCause(context.Background(), c.goAway.drainTimeout, ErrDrainTimeout)
+	defer cancel()
+
+	err := c.goAwayContext(ctx, CloseReason{Code: code, Message: message})
+	if err == context.DeadlineExceeded {
+		return ErrDrainTimeout
+	}
+	return err
+}
+
+// ShutdownContext gracefully shuts down the connection like GoAway with
+// CloseNormal, returning ctx.Err() if ctx is done before the peer finishes
+// draining
+func (c *conn) ShutdownContext(ctx context.Context) error {
+	return c.goAwayContext(ctx, CloseReason{Code: CloseNormal})
+}
+
+// goAwayContext sends the peer a GOAWAY for reason and waits for it to drain
+// until ctx is done
+func (c *conn) goAwayContext(ctx context.Context, reason CloseReason) error {
+	if !c.goAway.start(reason) {
+		select {
+		case <-c.ShutdownCh:
+			return nil
+		case <-ctx.Done():
+			c.shutdown(context.Cause(ctx))
+			return ctx.Err()
+		}
+	}
+
+	sent := make(chan struct{})
+	go func() {
+		c.goAway.sending.Wait()
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-ctx.Done():
+		c.shutdown(context.Cause(ctx))
+		return ctx.Err()
+	}
+
+	// A peer that doesn't acknowledge GOAWAYs has nothing left to drain
+	caps, err := c.waitHello(ctx)
+	if err == ErrShutdown {
+		return c.Err()
+	}
+	if err != nil {
+		c.shutdown(context.Cause(ctx))
+		return err
+	}
+	if !caps.Has(CapGoAway) {
+		c.shutdown(nil)
+		return nil
+	}
+
+	if err := c.sendFrameContext(ctx, c.control, goAwayFrame(goAwayFlag, reason)); err != nil {
+		c.shutdown(err)
+		return err
+	}
+
+	select {
+	case <-c.goAway.ack:
+		c.shutdown(nil)
+		return nil
+	case <-ctx.Done():
+		c.shutdown(context.Cause(ctx))
+		return ctx.Err()
+	case <-c.ShutdownCh:
+		return c.Err()
+	}
+}
+
+// recvGoAway handles a GOAWAY from the peer by acknowledging it once Sends
+// in progress finish, then shutting down. An ack completes our own GoAway.
+func (c *conn) recvGoAway(b []byte) {
+	if len(b) < goAwayHeaderLen {
+		c.lgr.Warnf("dropping malformed goaway frame\n")
+		return
+	}
+
+	if b[0] == goAwayAckFlag {
+		c.goAway.ackOnce.Do(func() {
+			close(c.goAway.ack)
+		})
+		return
+	}
+
+	reason := CloseReason{
+		Code:    CloseCode(binary.BigEndian.Uint32(b[1:])),
+		Message: string(b[goAwayHeaderLen:]),
+		Remote:  true,
+	}
+	c.lgr.Infof("Peer %s going away: %d %s", c.conn.RemoteAddr(), reason.Code, reason.Message)
+
+	// If we're already going away our own reason is kept
+	c.goAway.start(reason)
+
+	go func() {
+		c.goAway.sending.Wait()
+		if err := c.sendFrame(goAwayFrame(goAwayAckFlag, reason)); err != nil {
+			c.lgr.Warnf("Unable to acknowledge goaway: %s", err)
+		}
+		c.shutdown(nil)
+	}()
+}
+
+// CloseReason returns why the connection was closed, Code is CloseNone while
+// it is open
+func (c *conn) CloseReason() CloseReason {
+	c.goAway.lock.Lock()
+	going, reason := c.goAway.going, c.goAway.reason
+	c.goAway.lock.Unlock()
+	if going {
+		return reason
+	}
+
+	c.shutdownLock.Lock()
+	defer c.shutdownLock.Unlock()
+	switch {
+	case !c.isShutdown:
+		return CloseReason{Code: CloseNone}
+	case c.shutdownErr != nil:
+		return CloseReason{Code: CloseAbnormal, Message: c.shutdownErr.Error()}
+	default:
+		return CloseReason{Code: CloseNormal}
+	}
+}
@@ -0,0 +1,11 @@
+package gob
+
+import (
+	"testing"
+
+	"github.com/doubledutch/mux/benchmarks"
+)
+
+func BenchmarkConn(b *testing.B) {
+	benchmarks.Conn(b, new(Pool), NewDefaultConn)
+}
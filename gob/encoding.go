@@ -23,6 +23,28 @@ func NewBufferEncoder(buf *bytes.Buffer) mux.BufferEncoder {
 	}
 }
 
+// SelfDescribingBufferEncoder is a BufferEncoder that starts a new gob stream
+// on Reset, so every frame carries the type definitions it needs
+type SelfDescribingBufferEncoder struct {
+	*BufferEncoder
+}
+
+// NewSelfDescribingBufferEncoder creates a self-describing encoder
+func NewSelfDescribingBufferEncoder(buf *bytes.Buffer) mux.BufferEncoder {
+	return SelfDescribingBufferEncoder{
+		BufferEncoder: &BufferEncoder{
+			Buffer:  buf,
+			Encoder: gob.NewEncoder(buf),
+		},
+	}
+}
+
+// Reset resets the buffer and starts a new gob stream
+func (e SelfDescribingBufferEncoder) Reset() {
+	e.Buffer.Reset()
+	e.Encoder = gob.NewEncoder(e.Buffer)
+}
+
 // BufferDecoder is used to decode bytes to values
 type BufferDecoder struct {
 	*bytes.Buffer
@@ -38,3 +60,31 @@ func NewBufferDecoder(buf *bytes.Buffer) mux.BufferDecoder {
 		Decoder: dec,
 	}
 }
+
+// ResetStream starts decoding a new gob stream, from the new encoder the
+// sender replaced its encoder with
+func (d *BufferDecoder) ResetStream() {
+	d.Decoder = gob.NewDecoder(d.Buffer)
+}
+
+// SelfDescribingBufferDecoder is a BufferDecoder that starts a new gob stream
+// on Reset, decoding frames from a SelfDescribingBufferEncoder
+type SelfDescribingBufferDecoder struct {
+	*BufferDecoder
+}
+
+// NewSelfDescribingBufferDecoder creates a self-describing decoder
+func NewSelfDescribingBufferDecoder(buf *bytes.Buffer) mux.BufferDecoder {
+	return SelfDescribingBufferDecoder{
+		BufferDecoder: &BufferDecoder{
+			Buffer:  buf,
+			Decoder: gob.NewDecoder(buf),
+		},
+	}
+}
+
+// Reset resets the buffer and starts a new gob stream
+func (d SelfDescribingBufferDecoder) Reset() {
+	d.Buffer.Reset()
+	d.Decoder = gob.NewDecoder(d.Buffer)
+}
@@ -9,14 +9,36 @@ import (
 	"github.com/doubledutch/mux"
 )
 
+// Name is the encoding the Pool is registered as for negotiation
+const Name = "gob"
+
+func init() {
+	mux.Register(Name, new(Pool))
+}
+
+// Pool creates gob encoders and decoders.
+//
+// gob only sends the definition of a type the first time it's encoded, each
+// frame type has its own encoder and decoder so they stay in step. When Send
+// fails the Conn starts a new encoder and the peer's decoder is reset, but
+// frames dropped once queued, e.g. with SendDropOldest or a SendContext
+// cancelled before its frame is written, or receivers replaced need
+// SelfDescribing so every frame carries its type definitions.
 type Pool struct {
+	SelfDescribing bool
 }
 
 func (p *Pool) NewBufferEncoder() mux.BufferEncoder {
+	if p.SelfDescribing {
+		return NewSelfDescribingBufferEncoder(new(bytes.Buffer))
+	}
 	return NewBufferEncoder(new(bytes.Buffer))
 }
 
 func (p *Pool) NewBufferDecoder() mux.BufferDecoder {
+	if p.SelfDescribing {
+		return NewSelfDescribingBufferDecoder(new(bytes.Buffer))
+	}
 	return NewBufferDecoder(new(bytes.Buffer))
 }
 
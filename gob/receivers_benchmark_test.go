@@ -13,3 +13,7 @@ func BenchmarkStringReceiver(b *testing.B) {
 func BenchmarkValueReceiver(b *testing.B) {
 	benchmarks.ValueReceiver(b, new(Pool))
 }
+
+func BenchmarkTypedReceiver(b *testing.B) {
+	benchmarks.TypedReceiver(b, new(Pool))
+}
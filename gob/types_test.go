@@ -13,3 +13,11 @@ func TestHappyClientServer(t *testing.T) {
 func TestClientServerErr(t *testing.T) {
 	tests.ClientServerErr(t, new(Pool), NewDefaultServer, NewDefaultClient)
 }
+
+func TestWaitContext(t *testing.T) {
+	tests.WaitContext(t, NewDefaultServer, NewDefaultClient)
+}
+
+func TestSendStream(t *testing.T) {
+	tests.SendStream(t, NewDefaultServer, NewDefaultClient)
+}
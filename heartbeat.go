@@ -0,0 +1,167 @@
+package mux
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// pingLen is the length of a ping or pong frame
+	pingLen = 9
+	// pingFlag and pongFlag mark the first byte of a heartbeat frame
+	pingFlag uint8 = 0
+	pongFlag uint8 = 1
+
+	// DefaultMaxMissedPings is used when the Config doesn't specify
+	// MaxMissedPings
+	DefaultMaxMissedPings = 3
+)
+
+var (
+	// ErrHeartbeatTimeout is the error a Conn shuts down with when the peer
+	// stops answering pings
+	ErrHeartbeatTimeout = errors.New("Heartbeat timeout")
+)
+
+// heartbeat tracks pings sent to the peer and the round-trip time of pongs
+type heartbeat struct {
+	interval  time.Duration
+	maxMissed int
+
+	lock     sync.Mutex
+	seq      uint64
+	sent     time.Time
+	waiting  bool
+	missed   int
+	last     time.Duration
+	smoothed time.Duration
+
+	start sync.Once
+}
+
+func newHeartbeat(interval time.Duration, maxMissed int) *heartbeat {
+	if maxMissed == 0 {
+		maxMissed = DefaultMaxMissedPings
+	}
+
+	return &heartbeat{
+		interval:  interval,
+		maxMissed: maxMissed,
+	}
+}
+
+// heartbeatFrame creates a ping or pong frame for seq
+func heartbeatFrame(flag uint8, seq uint64) Frame {
+	b := make([]byte, pingLen)
+	b[0] = flag
+	binary.BigEndian.PutUint64(b[1:], seq)
+
+	return Frame{
+		Type: PingType,
+		Data: b,
+	}
+}
+
+// startHeartbeat starts pinging the peer, if configured, the first time Recv
+// is called since pongs can't arrive without it
+func (c *conn) startHeartbeat() {
+	if c.heartbeat.interval <= 0 {
+		return
+	}
+	c.heartbeat.start.Do(func() {
+		go c.ping()
+	})
+}
+
+// ping pings the peer every interval and shuts down the conn when too many
+// pings in a row go unanswered. It stops if either side doesn't support
+// heartbeats.
+func (c *conn) ping() {
+	h := c.heartbeat
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.ShutdownCh:
+			return
+		}
+
+		// A peer that never sends its hello is pinged like a dead one
+		if caps, ok := c.negotiated(); ok && !caps.Has(CapHeartbeat) {
+			c.lgr.Infof("Heartbeats with %s disabled", c.conn.RemoteAddr())
+			return
+		}
+
+		h.lock.Lock()
+		if h.waiting {
+			h.missed++
+		}
+		if h.missed > h.maxMissed {
+			h.lock.Unlock()
+			c.lgr.Errorf("Peer %s missed %d pings", c.conn.RemoteAddr(), h.maxMissed+1)
+			c.shutdown(ErrHeartbeatTimeout)
+			return
+		}
+		h.seq++
+		seq := h.seq
+		h.sent = time.Now()
+		h.waiting = true
+		h.lock.Unlock()
+
+		// Not waiting for the ping to be written, a dead peer can leave the
+		// writer stuck and the ping counts as missed all the same
+		ctx, cancel := context.WithTimeout(context.Background(), h.interval)
+		err := c.enqueue(ctx, c.control, newOutFrame(context.Background(), heartbeatFrame(pingFlag, seq)))
+		cancel()
+		if err != nil {
+			c.lgr.Warnf("Unable to ping %s: %s", c.conn.RemoteAddr(), err)
+		}
+	}
+}
+
+// recvPing answers pings and records the round-trip time of pongs
+func (c *conn) recvPing(b []byte) {
+	if len(b) != pingLen {
+		c.lgr.Warnf("dropping malformed ping frame\n")
+		return
+	}
+	seq := binary.BigEndian.Uint64(b[1:])
+
+	if b[0] == pingFlag {
+		c.queueFrame(heartbeatFrame(pongFlag, seq))
+		return
+	}
+
+	h := c.heartbeat
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	// Only the latest ping is timed, older pongs still show the peer is alive
+	h.missed = 0
+	if !h.waiting || seq != h.seq {
+		return
+	}
+	h.waiting = false
+
+	rtt := time.Since(h.sent)
+	h.last = rtt
+	if h.smoothed == 0 {
+		h.smoothed = rtt
+	} else {
+		h.smoothed = (7*h.smoothed + rtt) / 8
+	}
+}
+
+// RTT returns the latest and smoothed round-trip time of pings, both are zero
+// until the first pong arrives
+func (c *conn) RTT() (last, smoothed time.Duration) {
+	c.heartbeat.lock.Lock()
+	defer c.heartbeat.lock.Unlock()
+
+	return c.heartbeat.last, c.heartbeat.smoothed
+}
@@ -0,0 +1,196 @@
+package mux
+
+import (
+	"context"
+	"errors"
+	"math/bits"
+)
+
+// Capabilities are optional protocol features. Each side offers the ones it
+// supports in its hello, only those both offer are used.
+type Capabilities uint64
+
+const (
+	// CapHeartbeat means pings on PingType are answered
+	CapHeartbeat Capabilities = 1 << iota
+	// CapStreams means logical streams on StreamType are supported
+	CapStreams
+	// CapGoAway means GOAWAY frames are acknowledged
+	CapGoAway
+	// CapCompression means a CompressingPool may compress the frames it
+	// encodes
+	CapCompression
+	// CapSchema means Schema fingerprints are compared
+	CapSchema
+	// CapFragments means messages split into fragments are reassembled
+	CapFragments
+	// CapReliable means frames of reliable types are acknowledged
+	CapReliable
+
+	// AllCapabilities are the capabilities supported by this version
+	AllCapabilities = CapHeartbeat | CapStreams | CapGoAway | CapCompression | CapSchema | CapFragments | CapReliable
+
+	// legacyCapabilities are the capabilities of version 1 peers
+	legacyCapabilities = CapHeartbeat | CapStreams | CapGoAway
+
+	// compressionLevelShift is the first of the hello's compression level
+	// bits. A side offers one bit per level up to its own, ranked from
+	// HuffmanOnly through 9, so those both offer end at the lower level.
+	compressionLevelShift = 16
+	// compressionLevels are the hello's compression level bits, they're not
+	// returned by Capabilities
+	compressionLevels Capabilities = (1<<compressionLevelRanks - 1) << compressionLevelShift
+	// compressionLevelRanks is how many compression levels are ranked
+	compressionLevelRanks = 10
+)
+
+var (
+	// ErrStreamsUnsupported is returned by OpenStream when the peer doesn't
+	// support streams
+	ErrStreamsUnsupported = errors.New("Streams unsupported by peer")
+)
+
+// CompressingPool is implemented by Pools whose encoders compress, such as
+// the compress package's Pool. A Conn's encoders only compress once both
+// sides support CapCompression, including those created from Conn.Pool, and
+// then at the lower of both sides' levels. Levels are those of
+// compress/flate, from HuffmanOnly (-2) through BestCompression (9) with
+// DefaultCompression (-1) ranked as 6. A peer that doesn't offer a level
+// leaves the Pool's own.
+type CompressingPool interface {
+	Pool
+	// CompressionLevel returns the level the Pool compresses at
+	CompressionLevel() int
+	// WithCompression returns a Pool like this one whose encoders compress
+	// at the level returned by level, not at all while it returns zero
+	WithCompression(level func() int) Pool
+}
+
+// Has returns whether all of caps are in c
+func (c Capabilities) Has(caps Capabilities) bool {
+	return c&caps == caps
+}
+
+// hello tracks the capabilities offered and those agreed on with the peer
+type hello struct {
+	offered Capabilities
+	// negotiated is set before received is closed
+	negotiated Capabilities
+	// level is the compression level agreed on, set with negotiated
+	level    int
+	received chan struct{}
+}
+
+// newHello offers the capabilities config doesn't disable, CapCompression
+// and the Pool's compression level only when pool compresses
+func newHello(config *Config, pool Pool) *hello {
+	offered := AllCapabilities &^ config.DisableCapabilities
+	if cp, ok := pool.(CompressingPool); !ok {
+		offered &^= CapCompression
+	} else if offered.Has(CapCompression) {
+		offered |= compressionLevelCapabilities(cp.CompressionLevel())
+	}
+	return &hello{
+		offered:  offered,
+		received: make(chan struct{}),
+	}
+}
+
+// Capabilities waits for the peer's hello and returns the capabilities both
+// sides support, so Recv must be running. It returns zero if the connection
+// shuts down first.
+func (c *conn) Capabilities() Capabilities {
+	caps, _ := c.waitHello(context.Background())
+	return caps
+}
+
+// waitHello waits for the peer's hello until ctx is done
+func (c *conn) waitHello(ctx context.Context) (Capabilities, error) {
+	select {
+	case <-c.hello.received:
+		return c.hello.negotiated, nil
+	default:
+	}
+
+	select {
+	case <-c.hello.received:
+		return c.hello.negotiated, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-c.ShutdownCh:
+		return 0, ErrShutdown
+	}
+}
+
+// negotiated returns the capabilities both sides support, false until the
+// peer's hello arrives
+func (c *conn) negotiated() (Capabilities, bool) {
+	select {
+	case <-c.hello.received:
+		return c.hello.negotiated, true
+	default:
+		return 0, false
+	}
+}
+
+// readFrame reads the next frame, starting with the peer's hello
+func (c *conn) readFrame() (Frame, error) {
+	if _, ok := c.negotiated(); !ok {
+		caps, err := c.fr.readHello()
+		if err != nil {
+			return Frame{}, err
+		}
+		agreed := c.hello.offered & caps
+		c.hello.negotiated = agreed &^ compressionLevels
+		c.hello.level = compressionLevel(agreed)
+		if c.hello.level == 0 {
+			c.hello.level = compressionLevel(c.hello.offered)
+		}
+		close(c.hello.received)
+		c.lgr.Debugf("Peer %s capabilities %b, using %b", c.conn.RemoteAddr(), caps, c.hello.negotiated)
+
+		if c.schema != nil && c.hello.negotiated.Has(CapSchema) {
+			c.queueFrame(c.schemaFrame())
+		}
+	}
+
+	return c.fr.next()
+}
+
+// compressionLevel returns the level a CompressingPool compresses at, zero
+// until the hello arrives or when either side doesn't support CapCompression
+func (c *conn) compressionLevel() int {
+	caps, ok := c.negotiated()
+	if !ok || !caps.Has(CapCompression) {
+		return 0
+	}
+	return c.hello.level
+}
+
+// compressionLevelCapabilities returns the hello's bits for level, none for
+// levels out of range
+func compressionLevelCapabilities(level int) Capabilities {
+	rank := level
+	switch {
+	case level == -2:
+		rank = 0
+	case level == -1:
+		rank = 6
+	case level < 1 || level >= compressionLevelRanks:
+		return 0
+	}
+	return (1<<(rank+1) - 1) << compressionLevelShift
+}
+
+// compressionLevel returns the highest level of the hello's bits in caps,
+// zero if there are none
+func compressionLevel(caps Capabilities) int {
+	rank := bits.OnesCount64(uint64(caps&compressionLevels)) - 1
+	switch rank {
+	case -1:
+		return 0
+	case 0:
+		return -2
+	}
+	return rank
+}
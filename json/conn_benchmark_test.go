@@ -0,0 +1,11 @@
+package json
+
+import (
+	"testing"
+
+	"github.com/doubledutch/mux/benchmarks"
+)
+
+func BenchmarkConn(b *testing.B) {
+	benchmarks.Conn(b, new(Pool), NewDefaultConn)
+}
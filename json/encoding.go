@@ -38,3 +38,13 @@ func NewBufferDecoder(buf *bytes.Buffer) mux.BufferDecoder {
 		Decoder: dec,
 	}
 }
+
+// Decode decodes the buffer into v. A json.Decoder keeps its error and
+// buffered input, so it's replaced after an error to decode later frames.
+func (d *BufferDecoder) Decode(v interface{}) error {
+	err := d.Decoder.Decode(v)
+	if err != nil {
+		d.Decoder = json.NewDecoder(d.Buffer)
+	}
+	return err
+}
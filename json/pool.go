@@ -9,6 +9,13 @@ import (
 	"github.com/doubledutch/mux"
 )
 
+// Name is the encoding the Pool is registered as for negotiation
+const Name = "json"
+
+func init() {
+	mux.Register(Name, new(Pool))
+}
+
 type Pool struct {
 }
 
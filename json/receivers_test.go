@@ -13,3 +13,7 @@ func TestStringReceiver(t *testing.T) {
 func TestSignalReceiver(t *testing.T) {
 	tests.SignalReceiver(t, new(Pool))
 }
+
+func TestDroppedFrame(t *testing.T) {
+	tests.DroppedFrame(t, new(Pool))
+}
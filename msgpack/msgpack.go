@@ -0,0 +1,918 @@
+package msgpack
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/doubledutch/mux"
+)
+
+// MessagePack format codes, see https://github.com/msgpack/msgpack/blob/master/spec.md
+const (
+	codeNil      byte = 0xc0
+	codeFalse    byte = 0xc2
+	codeTrue     byte = 0xc3
+	codeBin8     byte = 0xc4
+	codeBin16    byte = 0xc5
+	codeBin32    byte = 0xc6
+	codeExt8     byte = 0xc7
+	codeExt16    byte = 0xc8
+	codeExt32    byte = 0xc9
+	codeFloat32  byte = 0xca
+	codeFloat64  byte = 0xcb
+	codeUint8    byte = 0xcc
+	codeUint16   byte = 0xcd
+	codeUint32   byte = 0xce
+	codeUint64   byte = 0xcf
+	codeInt8     byte = 0xd0
+	codeInt16    byte = 0xd1
+	codeInt32    byte = 0xd2
+	codeInt64    byte = 0xd3
+	codeFixExt1  byte = 0xd4
+	codeFixExt2  byte = 0xd5
+	codeFixExt4  byte = 0xd6
+	codeFixExt8  byte = 0xd7
+	codeFixExt16 byte = 0xd8
+	codeStr8     byte = 0xd9
+	codeStr16    byte = 0xda
+	codeStr32    byte = 0xdb
+	codeArray16  byte = 0xdc
+	codeArray32  byte = 0xdd
+	codeMap16    byte = 0xde
+	codeMap32    byte = 0xdf
+
+	fixMap   byte = 0x80
+	fixArray byte = 0x90
+	fixStr   byte = 0xa0
+	negFix   byte = 0xe0
+
+	// extTimestamp is the ext type of timestamps, -1
+	extTimestamp byte = 0xff
+
+	// maxDepth is how deeply arrays and maps may nest
+	maxDepth = 1000
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Encoder writes MessagePack values to an io.Writer
+type Encoder struct {
+	w   io.Writer
+	buf []byte
+}
+
+// NewEncoder returns an Encoder writing to w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the MessagePack encoding of v.
+//
+// Structs are encoded as maps keyed by field name, or the name in a
+// `msgpack:"name"` tag. Fields tagged `msgpack:"-"` are skipped, as are
+// fields tagged `msgpack:",omitempty"` holding a zero value. []byte is
+// encoded as bin and time.Time as the timestamp extension.
+func (e *Encoder) Encode(v interface{}) error {
+	b, err := appendValue(e.buf[:0], reflect.ValueOf(v))
+	e.buf = b[:0]
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(b)
+	return err
+}
+
+// Marshal returns the MessagePack encoding of v
+func Marshal(v interface{}) ([]byte, error) {
+	return appendValue(nil, reflect.ValueOf(v))
+}
+
+func appendValue(b []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() {
+		return append(b, codeNil), nil
+	}
+	if v.Type() == timeType {
+		return appendTime(b, v.Interface().(time.Time)), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, codeTrue), nil
+		}
+		return append(b, codeFalse), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendInt(b, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendUint(b, v.Uint()), nil
+	case reflect.Float32:
+		b = append(b, codeFloat32)
+		return binary.BigEndian.AppendUint32(b, math.Float32bits(float32(v.Float()))), nil
+	case reflect.Float64:
+		b = append(b, codeFloat64)
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(v.Float())), nil
+	case reflect.String:
+		return appendString(b, v.String()), nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return append(b, codeNil), nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return appendBytes(b, v.Bytes()), nil
+		}
+		return appendArray(b, v)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			bs := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(bs), v)
+			return appendBytes(b, bs), nil
+		}
+		return appendArray(b, v)
+	case reflect.Map:
+		if v.IsNil() {
+			return append(b, codeNil), nil
+		}
+		return appendMap(b, v)
+	case reflect.Struct:
+		return appendStruct(b, v)
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return append(b, codeNil), nil
+		}
+		return appendValue(b, v.Elem())
+	}
+	return b, fmt.Errorf("msgpack: unsupported type %s", v.Type())
+}
+
+func appendInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0:
+		return appendUint(b, uint64(i))
+	case i >= -32:
+		return append(b, byte(i))
+	case i >= math.MinInt8:
+		return append(b, codeInt8, byte(i))
+	case i >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(b, codeInt16), uint16(i))
+	case i >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(b, codeInt32), uint32(i))
+	}
+	return binary.BigEndian.AppendUint64(append(b, codeInt64), uint64(i))
+}
+
+func appendUint(b []byte, u uint64) []byte {
+	switch {
+	case u <= 0x7f:
+		return append(b, byte(u))
+	case u <= math.MaxUint8:
+		return append(b, codeUint8, byte(u))
+	case u <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, codeUint16), uint16(u))
+	case u <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, codeUint32), uint32(u))
+	}
+	return binary.BigEndian.AppendUint64(append(b, codeUint64), u)
+}
+
+func appendString(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		b = append(b, fixStr|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, codeStr8, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, codeStr16), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, codeStr32), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func appendBytes(b []byte, bs []byte) []byte {
+	n := len(bs)
+	switch {
+	case n <= math.MaxUint8:
+		b = append(b, codeBin8, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, codeBin16), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, codeBin32), uint32(n))
+	}
+	return append(b, bs...)
+}
+
+func appendArrayHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, fixArray|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, codeArray16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, codeArray32), uint32(n))
+}
+
+func appendMapHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, fixMap|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, codeMap16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, codeMap32), uint32(n))
+}
+
+func appendArray(b []byte, v reflect.Value) ([]byte, error) {
+	b = appendArrayHeader(b, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		var err error
+		if b, err = appendValue(b, v.Index(i)); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+func appendMap(b []byte, v reflect.Value) ([]byte, error) {
+	b = appendMapHeader(b, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		var err error
+		if b, err = appendValue(b, iter.Key()); err != nil {
+			return b, err
+		}
+		if b, err = appendValue(b, iter.Value()); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+func appendStruct(b []byte, v reflect.Value) ([]byte, error) {
+	fields := cachedFields(v.Type())
+
+	n := 0
+	for _, f := range fields {
+		if !f.omitEmpty || !v.FieldByIndex(f.index).IsZero() {
+			n++
+		}
+	}
+
+	b = appendMapHeader(b, n)
+	for _, f := range fields {
+		fv := v.FieldByIndex(f.index)
+		if f.omitEmpty && fv.IsZero() {
+			continue
+		}
+		b = appendString(b, f.name)
+		var err error
+		if b, err = appendValue(b, fv); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+// appendTime appends t as a timestamp ext in its smallest form
+func appendTime(b []byte, t time.Time) []byte {
+	sec, nsec := t.Unix(), uint32(t.Nanosecond())
+	switch {
+	case sec>>34 == 0 && nsec == 0 && sec <= math.MaxUint32:
+		b = append(b, codeFixExt4, extTimestamp)
+		return binary.BigEndian.AppendUint32(b, uint32(sec))
+	case sec>>34 == 0:
+		b = append(b, codeFixExt8, extTimestamp)
+		return binary.BigEndian.AppendUint64(b, uint64(nsec)<<34|uint64(sec))
+	}
+	b = append(b, codeExt8, 12, extTimestamp)
+	b = binary.BigEndian.AppendUint32(b, nsec)
+	return binary.BigEndian.AppendUint64(b, uint64(sec))
+}
+
+// field is an encoded struct field
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var fieldCache sync.Map // map[reflect.Type][]field
+
+func cachedFields(t reflect.Type) []field {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.([]field)
+	}
+	fields, _ := fieldCache.LoadOrStore(t, typeFields(t, nil))
+	return fields.([]field)
+}
+
+// typeFields returns the encoded fields of t, flattening embedded structs
+func typeFields(t reflect.Type, index []int) []field {
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("msgpack")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		fieldIndex := append(append([]int(nil), index...), i)
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			fields = append(fields, typeFields(sf.Type, fieldIndex)...)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, field{
+			name:      name,
+			index:     fieldIndex,
+			omitEmpty: opts == "omitempty",
+		})
+	}
+	return fields
+}
+
+// reader is what the Decoder reads from
+type reader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// Decoder reads MessagePack values from an io.Reader
+type Decoder struct {
+	r     reader
+	buf   []byte
+	depth int
+}
+
+// NewDecoder returns a Decoder reading from r. If r isn't an io.ByteReader
+// it is buffered, so the Decoder may read past the values it decodes.
+func NewDecoder(r io.Reader) *Decoder {
+	rr, ok := r.(reader)
+	if !ok {
+		rr = bufio.NewReader(r)
+	}
+	return &Decoder{r: rr}
+}
+
+// Decode reads the next MessagePack value into v, which must be a non-nil
+// pointer. Maps are decoded into structs by matching keys to field names,
+// case-insensitively when there isn't an exact match. Unknown keys are
+// skipped.
+func (d *Decoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("msgpack: Decode of non-pointer %T", v)
+	}
+	return d.value(rv.Elem())
+}
+
+// Unmarshal decodes the MessagePack value in b into v
+func Unmarshal(b []byte, v interface{}) error {
+	return NewDecoder(&byteReader{b: b}).Decode(v)
+}
+
+// byteReader is a reader over a []byte
+type byteReader struct {
+	b []byte
+}
+
+func (r *byteReader) Read(p []byte) (int, error) {
+	if len(r.b) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.b)
+	r.b = r.b[n:]
+	return n, nil
+}
+
+// Len returns the number of bytes left
+func (r *byteReader) Len() int {
+	return len(r.b)
+}
+
+func (r *byteReader) ReadByte() (byte, error) {
+	if len(r.b) == 0 {
+		return 0, io.EOF
+	}
+	c := r.b[0]
+	r.b = r.b[1:]
+	return c, nil
+}
+
+// read reads the next n bytes, which are only valid until the next read
+func (d *Decoder) read(n int) ([]byte, error) {
+	if cap(d.buf) < n {
+		d.buf = make([]byte, n)
+	}
+	b := d.buf[:n]
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// length reads a length of size bytes. Lengths are rejected beyond the bytes
+// left when the reader knows them, since every byte, element or entry takes
+// at least one byte, otherwise beyond mux.MaxFrameSize.
+func (d *Decoder) length(size int) (int, error) {
+	n, err := d.uint(size)
+	if err != nil {
+		return 0, err
+	}
+	if l, ok := d.r.(interface{ Len() int }); ok {
+		if n > uint64(l.Len()) {
+			return 0, fmt.Errorf("msgpack: length %d exceeds the %d bytes left", n, l.Len())
+		}
+	} else if n > mux.MaxFrameSize {
+		return 0, fmt.Errorf("msgpack: length %d too large", n)
+	}
+	return int(n), nil
+}
+
+// nest enters an array or map, failing beyond maxDepth. leave must be called
+// once it's decoded.
+func (d *Decoder) nest() error {
+	if d.depth >= maxDepth {
+		return fmt.Errorf("msgpack: nested deeper than %d", maxDepth)
+	}
+	d.depth++
+	return nil
+}
+
+func (d *Decoder) leave() {
+	d.depth--
+}
+
+func (d *Decoder) uint(n int) (uint64, error) {
+	b, err := d.read(n)
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (d *Decoder) value(v reflect.Value) error {
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	return d.valueCode(c, v)
+}
+
+func (d *Decoder) valueCode(c byte, v reflect.Value) error {
+	if c == codeNil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.valueCode(c, v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return fmt.Errorf("msgpack: cannot decode into non-empty interface %s", v.Type())
+		}
+		x, err := d.anyCode(c)
+		if err != nil {
+			return err
+		}
+		if x == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(x))
+		}
+		return nil
+	}
+
+	switch {
+	case c <= 0x7f || c >= negFix || (c >= codeFloat32 && c <= codeInt64):
+		return d.number(c, v)
+	case c == codeFalse || c == codeTrue:
+		if v.Kind() != reflect.Bool {
+			return decodeError("bool", v)
+		}
+		v.SetBool(c == codeTrue)
+		return nil
+	}
+
+	if n, ok, err := d.strLen(c); ok {
+		if err != nil {
+			return err
+		}
+		return d.bytes(n, "string", v)
+	}
+	if n, ok, err := d.binLen(c); ok {
+		if err != nil {
+			return err
+		}
+		return d.bytes(n, "bin", v)
+	}
+	if n, ok, err := d.arrayLen(c); ok {
+		if err != nil {
+			return err
+		}
+		return d.array(n, v)
+	}
+	if n, ok, err := d.mapLen(c); ok {
+		if err != nil {
+			return err
+		}
+		return d.mapValue(n, v)
+	}
+	if n, ok, err := d.extLen(c); ok {
+		if err != nil {
+			return err
+		}
+		return d.ext(n, v)
+	}
+	return fmt.Errorf("msgpack: invalid code %#x", c)
+}
+
+func decodeError(family string, v reflect.Value) error {
+	return fmt.Errorf("msgpack: cannot decode %s into %s", family, v.Type())
+}
+
+// number decodes an integer or float into v
+func (d *Decoder) number(c byte, v reflect.Value) error {
+	var (
+		i       int64
+		u       uint64
+		f       float64
+		isFloat bool
+		signed  bool
+		err     error
+	)
+	switch {
+	case c <= 0x7f:
+		u = uint64(c)
+	case c >= negFix:
+		i, signed = int64(int8(c)), true
+	case c == codeUint8, c == codeUint16, c == codeUint32, c == codeUint64:
+		u, err = d.uint(1 << (c - codeUint8))
+	case c == codeInt8, c == codeInt16, c == codeInt32, c == codeInt64:
+		n := 1 << (c - codeInt8)
+		u, err = d.uint(n)
+		shift := 64 - 8*n
+		i, signed = int64(u<<shift)>>shift, true
+	case c == codeFloat32:
+		u, err = d.uint(4)
+		f, isFloat = float64(math.Float32frombits(uint32(u))), true
+	case c == codeFloat64:
+		u, err = d.uint(8)
+		f, isFloat = math.Float64frombits(u), true
+	}
+	if err != nil {
+		return err
+	}
+	if signed && i >= 0 {
+		u, signed = uint64(i), false
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if isFloat {
+			return decodeError("float", v)
+		}
+		if !signed {
+			if u > math.MaxInt64 {
+				return decodeError("integer", v)
+			}
+			i = int64(u)
+		}
+		if v.OverflowInt(i) {
+			return fmt.Errorf("msgpack: %d overflows %s", i, v.Type())
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if isFloat || signed {
+			return decodeError("integer", v)
+		}
+		if v.OverflowUint(u) {
+			return fmt.Errorf("msgpack: %d overflows %s", u, v.Type())
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		switch {
+		case isFloat:
+		case signed:
+			f = float64(i)
+		default:
+			f = float64(u)
+		}
+		v.SetFloat(f)
+	default:
+		return decodeError("number", v)
+	}
+	return nil
+}
+
+func (d *Decoder) strLen(c byte) (int, bool, error) {
+	switch {
+	case c&0xe0 == fixStr:
+		return int(c & 0x1f), true, nil
+	case c == codeStr8, c == codeStr16, c == codeStr32:
+		n, err := d.length(1 << (c - codeStr8))
+		return n, true, err
+	}
+	return 0, false, nil
+}
+
+func (d *Decoder) binLen(c byte) (int, bool, error) {
+	switch c {
+	case codeBin8, codeBin16, codeBin32:
+		n, err := d.length(1 << (c - codeBin8))
+		return n, true, err
+	}
+	return 0, false, nil
+}
+
+func (d *Decoder) arrayLen(c byte) (int, bool, error) {
+	switch {
+	case c&0xf0 == fixArray:
+		return int(c & 0x0f), true, nil
+	case c == codeArray16:
+		n, err := d.length(2)
+		return n, true, err
+	case c == codeArray32:
+		n, err := d.length(4)
+		return n, true, err
+	}
+	return 0, false, nil
+}
+
+func (d *Decoder) mapLen(c byte) (int, bool, error) {
+	switch {
+	case c&0xf0 == fixMap:
+		return int(c & 0x0f), true, nil
+	case c == codeMap16:
+		n, err := d.length(2)
+		return n, true, err
+	case c == codeMap32:
+		n, err := d.length(4)
+		return n, true, err
+	}
+	return 0, false, nil
+}
+
+// extLen returns the length of an ext's data, the type byte follows
+func (d *Decoder) extLen(c byte) (int, bool, error) {
+	switch c {
+	case codeFixExt1, codeFixExt2, codeFixExt4, codeFixExt8, codeFixExt16:
+		return 1 << (c - codeFixExt1), true, nil
+	case codeExt8, codeExt16, codeExt32:
+		n, err := d.length(1 << (c - codeExt8))
+		return n, true, err
+	}
+	return 0, false, nil
+}
+
+// bytes decodes a string or bin of length n into v
+func (d *Decoder) bytes(n int, family string, v reflect.Value) error {
+	b, err := d.read(n)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(string(b))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		v.SetBytes(append([]byte{}, b...))
+	case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+		if n > v.Len() {
+			return fmt.Errorf("msgpack: %d bytes overflow %s", n, v.Type())
+		}
+		reflect.Copy(v, reflect.ValueOf(b))
+		for i := n; i < v.Len(); i++ {
+			v.Index(i).SetUint(0)
+		}
+	default:
+		return decodeError(family, v)
+	}
+	return nil
+}
+
+// array decodes an array of n elements into v, slices grow as elements are
+// decoded rather than trusting n up front
+func (d *Decoder) array(n int, v reflect.Value) error {
+	if err := d.nest(); err != nil {
+		return err
+	}
+	defer d.leave()
+
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.Cap() >= n {
+			v.SetLen(n)
+			break
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+		}
+		v.SetLen(v.Cap())
+		zero := reflect.Zero(v.Type().Elem())
+		for i := 0; i < n; i++ {
+			if i == v.Len() {
+				v.Set(reflect.Append(v, zero))
+			}
+			if err := d.value(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Array:
+		if n > v.Len() {
+			return fmt.Errorf("msgpack: %d elements overflow %s", n, v.Type())
+		}
+		for i := n; i < v.Len(); i++ {
+			v.Index(i).Set(reflect.Zero(v.Type().Elem()))
+		}
+	default:
+		return decodeError("array", v)
+	}
+
+	for i := 0; i < n; i++ {
+		if err := d.value(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Decoder) mapValue(n int, v reflect.Value) error {
+	if err := d.nest(); err != nil {
+		return err
+	}
+	defer d.leave()
+
+	switch v.Kind() {
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		for i := 0; i < n; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := d.value(key); err != nil {
+				return err
+			}
+			if key.Kind() == reflect.Interface && !key.IsNil() && !key.Elem().Type().Comparable() {
+				return fmt.Errorf("msgpack: invalid map key %s", key.Elem().Type())
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := d.value(elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+		}
+		return nil
+	case reflect.Struct:
+		fields := cachedFields(v.Type())
+		for i := 0; i < n; i++ {
+			var name string
+			if err := d.value(reflect.ValueOf(&name).Elem()); err != nil {
+				return err
+			}
+			f, ok := findField(fields, name)
+			if !ok {
+				if _, err := d.any(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.value(v.FieldByIndex(f.index)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return decodeError("map", v)
+}
+
+func findField(fields []field, name string) (field, bool) {
+	for _, f := range fields {
+		if f.name == name {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.name, name) {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+// ext decodes an ext of length n, only timestamps are supported
+func (d *Decoder) ext(n int, v reflect.Value) error {
+	t, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	b, err := d.read(n)
+	if err != nil {
+		return err
+	}
+	if t != extTimestamp {
+		return fmt.Errorf("msgpack: unsupported ext type %d", int8(t))
+	}
+	if v.Type() != timeType {
+		return decodeError("timestamp", v)
+	}
+
+	var ts time.Time
+	switch n {
+	case 4:
+		ts = time.Unix(int64(binary.BigEndian.Uint32(b)), 0)
+	case 8:
+		u := binary.BigEndian.Uint64(b)
+		ts = time.Unix(int64(u&(1<<34-1)), int64(u>>34))
+	case 12:
+		ts = time.Unix(int64(binary.BigEndian.Uint64(b[4:])), int64(binary.BigEndian.Uint32(b)))
+	default:
+		return fmt.Errorf("msgpack: invalid timestamp length %d", n)
+	}
+	v.Set(reflect.ValueOf(ts))
+	return nil
+}
+
+// any decodes the next value into an interface{}
+func (d *Decoder) any() (interface{}, error) {
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	return d.anyCode(c)
+}
+
+// anyCode decodes a value into its natural Go type: nil, bool, int64,
+// uint64, float32, float64, string, []byte, []interface{},
+// map[interface{}]interface{} or time.Time
+func (d *Decoder) anyCode(c byte) (interface{}, error) {
+	var v reflect.Value
+	switch {
+	case c == codeNil:
+		return nil, nil
+	case c == codeFalse || c == codeTrue:
+		return c == codeTrue, nil
+	case c <= 0x7f || c == codeUint8 || c == codeUint16 || c == codeUint32 || c == codeUint64:
+		var u uint64
+		v = reflect.ValueOf(&u).Elem()
+	case c >= negFix || c == codeInt8 || c == codeInt16 || c == codeInt32 || c == codeInt64:
+		var i int64
+		v = reflect.ValueOf(&i).Elem()
+	case c == codeFloat32:
+		var f float32
+		v = reflect.ValueOf(&f).Elem()
+	case c == codeFloat64:
+		var f float64
+		v = reflect.ValueOf(&f).Elem()
+	case c&0xe0 == fixStr || c == codeStr8 || c == codeStr16 || c == codeStr32:
+		var s string
+		v = reflect.ValueOf(&s).Elem()
+	case c == codeBin8 || c == codeBin16 || c == codeBin32:
+		var b []byte
+		v = reflect.ValueOf(&b).Elem()
+	case c&0xf0 == fixArray || c == codeArray16 || c == codeArray32:
+		var a []interface{}
+		v = reflect.ValueOf(&a).Elem()
+	case c&0xf0 == fixMap || c == codeMap16 || c == codeMap32:
+		var m map[interface{}]interface{}
+		v = reflect.ValueOf(&m).Elem()
+	default:
+		var t time.Time
+		v = reflect.ValueOf(&t).Elem()
+	}
+
+	if err := d.valueCode(c, v); err != nil {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
@@ -0,0 +1,199 @@
+package msgpack
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type embedded struct {
+	E int
+}
+
+type tagged struct {
+	embedded
+	Name    string `msgpack:"name"`
+	Skipped int    `msgpack:"-"`
+	Empty   string `msgpack:",omitempty"`
+	hidden  int
+}
+
+func TestMarshal(t *testing.T) {
+	for _, tc := range []struct {
+		v        interface{}
+		expected []byte
+	}{
+		{nil, []byte{0xc0}},
+		{true, []byte{0xc3}},
+		{false, []byte{0xc2}},
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0xcc, 0x80}},
+		{-1, []byte{0xff}},
+		{-32, []byte{0xe0}},
+		{-33, []byte{0xd0, 0xdf}},
+		{256, []byte{0xcd, 0x01, 0x00}},
+		{-129, []byte{0xd1, 0xff, 0x7f}},
+		{uint64(math.MaxUint64), []byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{float32(1.5), []byte{0xca, 0x3f, 0xc0, 0, 0}},
+		{"abc", []byte{0xa3, 'a', 'b', 'c'}},
+		{[]byte{1, 2}, []byte{0xc4, 0x02, 0x01, 0x02}},
+		{[]int{1, 2}, []byte{0x92, 0x01, 0x02}},
+		{map[string]int{"a": 1}, []byte{0x81, 0xa1, 'a', 0x01}},
+		{tagged{embedded: embedded{E: 1}, Name: "x", Skipped: 2}, []byte{0x82, 0xa1, 'E', 0x01, 0xa4, 'n', 'a', 'm', 'e', 0xa1, 'x'}},
+		{time.Unix(1, 0), []byte{0xd6, 0xff, 0, 0, 0, 1}},
+	} {
+		actual, err := Marshal(tc.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, tc.expected) {
+			t.Fatalf("%v: expected %x, got %x", tc.v, tc.expected, actual)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	type inner struct {
+		A []string
+		B map[string]float64
+	}
+	type outer struct {
+		Int    int64
+		Uint   uint32
+		Bytes  []byte
+		Array  [3]byte
+		Ptr    *inner
+		Inner  inner
+		Time   time.Time
+		Any    interface{}
+		Long   string
+		Signed []int16
+	}
+
+	expected := outer{
+		Int:    math.MinInt64,
+		Uint:   math.MaxUint32,
+		Bytes:  bytes.Repeat([]byte{1}, 300),
+		Array:  [3]byte{1, 2, 3},
+		Ptr:    &inner{A: []string{"a", "b"}},
+		Inner:  inner{B: map[string]float64{"pi": math.Pi}},
+		Time:   time.Unix(1<<35, 5).UTC(),
+		Any:    "any",
+		Long:   strings.Repeat("x", 70000),
+		Signed: []int16{-200, 200},
+	}
+
+	b, err := Marshal(&expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual outer
+	if err := Unmarshal(b, &actual); err != nil {
+		t.Fatal(err)
+	}
+	actual.Time = actual.Time.UTC()
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, actual)
+	}
+}
+
+func TestDecodeAny(t *testing.T) {
+	b, err := Marshal(map[string]interface{}{
+		"list": []interface{}{-1, 1, "s", nil, true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual interface{}
+	if err := Unmarshal(b, &actual); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[interface{}]interface{}{
+		"list": []interface{}{int64(-1), uint64(1), "s", nil, true},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, actual)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	var i8 int8
+	if err := Unmarshal([]byte{0xcc, 0xff}, &i8); err == nil {
+		t.Fatal("expected overflow error")
+	}
+
+	var u uint
+	if err := Unmarshal([]byte{0xff}, &u); err == nil {
+		t.Fatal("expected error decoding a negative integer into uint")
+	}
+
+	var s string
+	if err := Unmarshal([]byte{0xa3, 'a'}, &s); err == nil {
+		t.Fatal("expected error decoding a truncated string")
+	}
+
+	if err := Unmarshal([]byte{0xc0}, s); err == nil {
+		t.Fatal("expected error decoding into a non-pointer")
+	}
+
+	// Unknown fields are skipped, names match case-insensitively
+	var args struct{ A, B int }
+	b := []byte{0x83, 0xa1, 'a', 0x01, 0xa1, 'C', 0x92, 0x01, 0x02, 0xa1, 'B', 0x02}
+	if err := Unmarshal(b, &args); err != nil {
+		t.Fatal(err)
+	}
+	if args.A != 1 || args.B != 2 {
+		t.Fatalf("expected {1 2}, got %v", args)
+	}
+}
+
+func TestDecodeOversized(t *testing.T) {
+	payloads := map[string][]byte{
+		"array32": {0xdd, 0x7f, 0xff, 0xff, 0xff},
+		"str32":   {0xdb, 0x7f, 0xff, 0xff, 0xff},
+		"bin32":   {0xc6, 0x7f, 0xff, 0xff, 0xff},
+		"map32":   {0xdf, 0x7f, 0xff, 0xff, 0xff},
+		"ext32":   {0xc9, 0x7f, 0xff, 0xff, 0xff, 0xff},
+		// Within mux.MaxFrameSize but not the input
+		"array32 short": {0xdd, 0x00, 0x10, 0x00, 0x00, 0xa1, 'a'},
+	}
+	targets := map[string]func() interface{}{
+		"[]string":    func() interface{} { return new([]string) },
+		"string":      func() interface{} { return new(string) },
+		"[]byte":      func() interface{} { return new([]byte) },
+		"map":         func() interface{} { return new(map[string]string) },
+		"interface{}": func() interface{} { return new(interface{}) },
+	}
+	for name, b := range payloads {
+		for target, v := range targets {
+			if err := Unmarshal(b, v()); err == nil {
+				t.Fatalf("expected error decoding %s into %s", name, target)
+			}
+			// Without knowing the bytes left, lengths are still bounded
+			r := io.MultiReader(bytes.NewReader(b))
+			if err := NewDecoder(r).Decode(v()); err == nil {
+				t.Fatalf("expected error streaming %s into %s", name, target)
+			}
+		}
+	}
+}
+
+func TestDecodeDepth(t *testing.T) {
+	b := append(bytes.Repeat([]byte{0x91}, maxDepth), 0xc0)
+	var v interface{}
+	if err := Unmarshal(b, &v); err != nil {
+		t.Fatal(err)
+	}
+
+	b = append(bytes.Repeat([]byte{0x91}, maxDepth+1), 0xc0)
+	if err := Unmarshal(b, &v); err == nil || !strings.Contains(err.Error(), "nested") {
+		t.Fatalf("expected nesting error, got %v", err)
+	}
+}
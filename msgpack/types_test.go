@@ -0,0 +1,23 @@
+package msgpack
+
+import (
+	"testing"
+
+	"github.com/doubledutch/mux/tests"
+)
+
+func TestHappyClientServer(t *testing.T) {
+	tests.HappyClientServer(t, new(Pool), NewDefaultServer, NewDefaultClient)
+}
+
+func TestClientServerErr(t *testing.T) {
+	tests.ClientServerErr(t, new(Pool), NewDefaultServer, NewDefaultClient)
+}
+
+func TestWaitContext(t *testing.T) {
+	tests.WaitContext(t, NewDefaultServer, NewDefaultClient)
+}
+
+func TestSendStream(t *testing.T) {
+	tests.SendStream(t, NewDefaultServer, NewDefaultClient)
+}
@@ -0,0 +1,289 @@
+package mux
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Encoding negotiation
+//
+// NewClientConn and NewServerConn agree on a Pool before the Conn starts.
+// The client offers the names of its encodings in order of preference:
+//
+//	["MUXN"][version uint8][count uint8]([length uint8][name])...
+//
+// The server picks the first one it has registered and replies:
+//
+//	["MUXN"][version uint8][status uint8][length uint8][name]
+//
+// where the name is empty unless the status is negotiateOK.
+
+const (
+	// negotiateVersion is the version of the negotiation handshake
+	negotiateVersion uint8 = 1
+
+	// statuses of the server's reply
+	negotiateOK         uint8 = 0
+	negotiateNoEncoding uint8 = 1
+	negotiateBadVersion uint8 = 2
+
+	negotiateHeaderLen = 4 + 1
+
+	maxEncodingNameLen    = 255
+	maxNegotiateEncodings = 255
+
+	// DefaultHandshakeTimeout is used when the Config doesn't specify
+	// HandshakeTimeout
+	DefaultHandshakeTimeout = 10 * time.Second
+)
+
+var (
+	negotiateMagic = []byte("MUXN")
+
+	// ErrNoCommonEncoding is returned by NewClientConn and NewServerConn
+	// when the peers don't share an encoding
+	ErrNoCommonEncoding = errors.New("No common encoding")
+	// ErrInvalidHandshake is returned when the peer doesn't speak the
+	// negotiation handshake
+	ErrInvalidHandshake = errors.New("Invalid handshake")
+	// ErrInvalidEncodings defines an error for an invalid Config Encodings
+	// value
+	ErrInvalidEncodings = errors.New("Invalid Config Encodings")
+	// ErrInvalidHandshakeTimeout defines an error for an invalid Config
+	// HandshakeTimeout value
+	ErrInvalidHandshakeTimeout = errors.New("Invalid Config HandshakeTimeout")
+)
+
+// Registry maps encoding names to Pools
+type Registry struct {
+	lock  sync.RWMutex
+	names []string
+	pools map[string]Pool
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		pools: make(map[string]Pool),
+	}
+}
+
+// Register makes pool available as name. It panics if name is invalid or
+// already registered.
+func (r *Registry) Register(name string, pool Pool) {
+	if name == "" || len(name) > maxEncodingNameLen {
+		panic("mux: invalid encoding name " + name)
+	}
+	if pool == nil {
+		panic("mux: Register pool is nil")
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.pools[name]; ok {
+		panic("mux: Register called twice for encoding " + name)
+	}
+	r.names = append(r.names, name)
+	r.pools[name] = pool
+}
+
+// Pool returns the Pool registered as name, or nil
+func (r *Registry) Pool(name string) Pool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.pools[name]
+}
+
+// Names returns the registered names in the order they were registered
+func (r *Registry) Names() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return append([]string(nil), r.names...)
+}
+
+// DefaultRegistry is the Registry used when the Config doesn't specify one.
+// Encoding packages register their Pool in it when imported.
+var DefaultRegistry = NewRegistry()
+
+// Register makes pool available as name in DefaultRegistry
+func Register(name string, pool Pool) {
+	DefaultRegistry.Register(name, pool)
+}
+
+// NewClientConn negotiates an encoding with the server on netConn, offering
+// config.Encodings, then creates a Conn using its Pool
+func NewClientConn(netConn net.Conn, config *Config) (Conn, error) {
+	if err := config.Verify(); err != nil {
+		return nil, err
+	}
+
+	registry, encodings, err := config.negotiable()
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := negotiate(netConn, config.handshakeTimeout(), func() (string, error) {
+		return clientNegotiate(netConn, encodings)
+	})
+	if err != nil {
+		return nil, err
+	}
+	config.Lager.Debugf("Negotiated encoding %s\n", name)
+	return NewConn(netConn, registry.Pool(name), config)
+}
+
+// NewServerConn waits for a client on netConn to offer its encodings and
+// creates a Conn using the Pool of the client's most preferred encoding
+// that's in config.Encodings
+func NewServerConn(netConn net.Conn, config *Config) (Conn, error) {
+	if err := config.Verify(); err != nil {
+		return nil, err
+	}
+
+	registry, encodings, err := config.negotiable()
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := negotiate(netConn, config.handshakeTimeout(), func() (string, error) {
+		return serverNegotiate(netConn, encodings)
+	})
+	if err != nil {
+		return nil, err
+	}
+	config.Lager.Debugf("Negotiated encoding %s\n", name)
+	return NewConn(netConn, registry.Pool(name), config)
+}
+
+// negotiate runs f with a deadline on netConn
+func negotiate(netConn net.Conn, timeout time.Duration, f func() (string, error)) (string, error) {
+	if err := netConn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return "", err
+	}
+	name, err := f()
+	if err != nil {
+		return "", err
+	}
+	return name, netConn.SetDeadline(time.Time{})
+}
+
+// clientNegotiate offers encodings and returns the one the server chose
+func clientNegotiate(rw io.ReadWriter, encodings []string) (string, error) {
+	b := make([]byte, 0, 64)
+	b = append(b, negotiateMagic...)
+	b = append(b, negotiateVersion, uint8(len(encodings)))
+	for _, name := range encodings {
+		b = append(b, uint8(len(name)))
+		b = append(b, name...)
+	}
+	if _, err := rw.Write(b); err != nil {
+		return "", err
+	}
+
+	if err := readNegotiateHeader(rw); err != nil {
+		return "", err
+	}
+	status, err := readByte(rw)
+	if err != nil {
+		return "", err
+	}
+	name, err := readEncodingName(rw)
+	if err != nil {
+		return "", err
+	}
+
+	switch status {
+	case negotiateOK:
+	case negotiateNoEncoding:
+		return "", ErrNoCommonEncoding
+	case negotiateBadVersion:
+		return "", ErrUnsupportedVersion
+	default:
+		return "", ErrInvalidHandshake
+	}
+	for _, offered := range encodings {
+		if name == offered {
+			return name, nil
+		}
+	}
+	return "", ErrInvalidHandshake
+}
+
+// serverNegotiate reads the client's offer and replies with the first
+// encoding that's also in encodings
+func serverNegotiate(rw io.ReadWriter, encodings []string) (string, error) {
+	err := readNegotiateHeader(rw)
+	if err == ErrUnsupportedVersion {
+		writeNegotiateReply(rw, negotiateBadVersion, "")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	n, err := readByte(rw)
+	if err != nil {
+		return "", err
+	}
+	offered := make([]string, n)
+	for i := range offered {
+		if offered[i], err = readEncodingName(rw); err != nil {
+			return "", err
+		}
+	}
+
+	for _, name := range offered {
+		for _, supported := range encodings {
+			if name == supported {
+				return name, writeNegotiateReply(rw, negotiateOK, name)
+			}
+		}
+	}
+
+	writeNegotiateReply(rw, negotiateNoEncoding, "")
+	return "", ErrNoCommonEncoding
+}
+
+func writeNegotiateReply(w io.Writer, status uint8, name string) error {
+	b := make([]byte, 0, negotiateHeaderLen+2+len(name))
+	b = append(b, negotiateMagic...)
+	b = append(b, negotiateVersion, status, uint8(len(name)))
+	b = append(b, name...)
+	_, err := w.Write(b)
+	return err
+}
+
+func readNegotiateHeader(r io.Reader) error {
+	b := make([]byte, negotiateHeaderLen)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	if !bytes.Equal(b[:len(negotiateMagic)], negotiateMagic) {
+		return ErrInvalidHandshake
+	}
+	if b[len(negotiateMagic)] != negotiateVersion {
+		return ErrUnsupportedVersion
+	}
+	return nil
+}
+
+func readEncodingName(r io.Reader) (string, error) {
+	n, err := readByte(r)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func readByte(r io.Reader) (uint8, error) {
+	var b [1]byte
+	_, err := io.ReadFull(r, b[:])
+	return b[0], err
+}
@@ -0,0 +1,39 @@
+package raw
+
+import (
+	"bytes"
+
+	"github.com/doubledutch/mux"
+)
+
+// BufferEncoder is used to encode values to bytes
+type BufferEncoder struct {
+	*bytes.Buffer
+	mux.Encoder
+}
+
+// NewBufferEncoder creates a encoder
+func NewBufferEncoder(buf *bytes.Buffer) mux.BufferEncoder {
+	enc := NewEncoder(buf)
+
+	return &BufferEncoder{
+		Buffer:  buf,
+		Encoder: enc,
+	}
+}
+
+// BufferDecoder is used to decode bytes to values
+type BufferDecoder struct {
+	*bytes.Buffer
+	mux.Decoder
+}
+
+// NewBufferDecoder creates a decoder
+func NewBufferDecoder(buf *bytes.Buffer) mux.BufferDecoder {
+	dec := NewDecoder(buf)
+
+	return &BufferDecoder{
+		Buffer:  buf,
+		Decoder: dec,
+	}
+}
@@ -0,0 +1,53 @@
+package raw
+
+import (
+	"bytes"
+	"io"
+	"net"
+
+	"github.com/doubledutch/mux"
+)
+
+// Name is the encoding the Pool is registered as for negotiation
+const Name = "raw"
+
+func init() {
+	mux.Register(Name, new(Pool))
+}
+
+// Pool passes []byte, string and io.Reader values through untouched
+type Pool struct {
+}
+
+func (p *Pool) NewBufferEncoder() mux.BufferEncoder {
+	return NewBufferEncoder(new(bytes.Buffer))
+}
+
+func (p *Pool) NewBufferDecoder() mux.BufferDecoder {
+	return NewBufferDecoder(new(bytes.Buffer))
+}
+
+func (p *Pool) NewEncoder(w io.Writer) mux.Encoder {
+	return NewEncoder(w)
+}
+
+func (p *Pool) NewDecoder(r io.Reader) mux.Decoder {
+	return NewDecoder(r)
+}
+
+// NewReceiver creates a mux.BytesReceiver for a chan []byte, which delivers
+// frame data without decoding it
+func (p *Pool) NewReceiver(ch interface{}) mux.Receiver {
+	if ch, ok := ch.(chan []byte); ok {
+		return mux.NewBytesReceiver(ch)
+	}
+	return mux.NewReceiver(ch, p)
+}
+
+func (p *Pool) NewServer(conn net.Conn, config *mux.Config) (mux.Server, error) {
+	return NewServer(conn, config)
+}
+
+func (p *Pool) NewClient(conn net.Conn, config *mux.Config) (mux.Client, error) {
+	return NewClient(conn, config)
+}
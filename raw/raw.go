@@ -0,0 +1,67 @@
+package raw
+
+import (
+	"fmt"
+	"io"
+)
+
+// Encoder writes []byte, string and io.Reader values to an io.Writer as is
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns an Encoder writing to w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes v, which must be a []byte, string, io.Reader or a pointer to
+// a []byte or string
+func (e *Encoder) Encode(v interface{}) error {
+	var err error
+	switch v := v.(type) {
+	case []byte:
+		_, err = e.w.Write(v)
+	case *[]byte:
+		_, err = e.w.Write(*v)
+	case string:
+		_, err = io.WriteString(e.w, v)
+	case *string:
+		_, err = io.WriteString(e.w, *v)
+	case io.Reader:
+		_, err = io.Copy(e.w, v)
+	default:
+		err = fmt.Errorf("raw: unsupported type %T", v)
+	}
+	return err
+}
+
+// Decoder reads everything from an io.Reader into a value. Since raw values
+// have no framing, a Decoder reads until io.EOF.
+type Decoder struct {
+	r io.Reader
+}
+
+// NewDecoder returns a Decoder reading from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Decode reads everything into v, which must be a *[]byte, *string or
+// io.Writer
+func (d *Decoder) Decode(v interface{}) error {
+	switch v := v.(type) {
+	case *[]byte:
+		b, err := io.ReadAll(d.r)
+		*v = b
+		return err
+	case *string:
+		b, err := io.ReadAll(d.r)
+		*v = string(b)
+		return err
+	case io.Writer:
+		_, err := io.Copy(v, d.r)
+		return err
+	}
+	return fmt.Errorf("raw: unsupported type %T", v)
+}
@@ -0,0 +1,15 @@
+package raw
+
+import (
+	"testing"
+
+	"github.com/doubledutch/mux/tests"
+)
+
+func TestStringReceiver(t *testing.T) {
+	tests.StringReceiver(t, new(Pool))
+}
+
+func TestBytesReceiver(t *testing.T) {
+	tests.BytesReceiver(t, new(Pool))
+}
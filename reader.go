@@ -0,0 +1,206 @@
+package mux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// SendStream sends the contents of an io.Reader as chunks on a frame type,
+// each frame starting with its kind:
+//
+//	[chunkData][data] | [chunkEnd] | [chunkError][message]
+//
+// A ReaderReceiver reads them back as an io.ReadCloser per stream.
+
+const (
+	// chunkData carries the next bytes of the stream
+	chunkData uint8 = iota
+	// chunkEnd ends the stream
+	chunkEnd
+	// chunkError ends the stream with the sender's read error
+	chunkError
+)
+
+const (
+	// chunkSize is the most data read from the io.Reader for each frame
+	chunkSize = 32 * 1024
+	// chunkQueueSize is the number of chunks waiting to be read before Recv
+	// waits for the reader
+	chunkQueueSize = 16
+)
+
+// ErrInvalidChunk is returned by ReaderReceiver for a frame that isn't a
+// SendStream chunk
+var ErrInvalidChunk = errors.New("Invalid stream chunk")
+
+// SendStream sends the contents of r on t in chunks followed by an
+// end-of-stream marker, for a ReaderReceiver. A read error other than io.EOF
+// ends the stream with that error on both sides. Other frames of t wait for
+// the stream to end.
+func (c *conn) SendStream(t uint8, r io.Reader) error {
+	if err := c.checkDrift(t); err != nil {
+		return err
+	}
+
+	enc := c.sendEncoder(t)
+	enc.Lock()
+	defer enc.Unlock()
+
+	// Each chunk is written before the buffer is reused
+	buf := make([]byte, 1+chunkSize)
+	send := func(b []byte) error {
+		f, err := c.queueRaw(t, b)
+		if err != nil {
+			return err
+		}
+		return c.wait(context.Background(), f)
+	}
+	for {
+		n, err := r.Read(buf[1:])
+		if n > 0 {
+			buf[0] = chunkData
+			if err := send(buf[:1+n]); err != nil {
+				return err
+			}
+		}
+
+		switch {
+		case err == io.EOF:
+			return send([]byte{chunkEnd})
+		case err != nil:
+			if serr := send(append([]byte{chunkError}, err.Error()...)); serr != nil {
+				return serr
+			}
+			return err
+		}
+	}
+}
+
+// ReaderReceiver receives the streams sent by SendStream. Each stream is put
+// on ch as an io.ReadCloser when it starts. Recv waits for chunks to be read,
+// so every stream must be read or closed.
+type ReaderReceiver struct {
+	ch     chan io.ReadCloser
+	closed chan struct{}
+	once   sync.Once
+
+	// the stream being received, only used by Receive
+	current *streamReader
+}
+
+// NewReaderReceiver creates a ReaderReceiver putting streams on ch, it's
+// closed with the Conn
+func NewReaderReceiver(ch chan io.ReadCloser) *ReaderReceiver {
+	return &ReaderReceiver{
+		ch:     ch,
+		closed: make(chan struct{}),
+	}
+}
+
+// Receive adds a chunk to the current stream, starting a new one if needed
+func (r *ReaderReceiver) Receive(b []byte) error {
+	if len(b) == 0 || b[0] > chunkError {
+		return ErrInvalidChunk
+	}
+
+	if r.current == nil {
+		r.current = newStreamReader(r.closed)
+		select {
+		case r.ch <- r.current:
+		case <-r.closed:
+			return nil
+		}
+	}
+
+	s := r.current
+	switch b[0] {
+	case chunkData:
+		select {
+		case s.chunks <- b[1:]:
+		case <-s.done:
+			// Closed by the reader, the rest is dropped
+		case <-r.closed:
+		}
+	case chunkEnd:
+		r.current = nil
+		s.end(io.EOF)
+	case chunkError:
+		r.current = nil
+		s.end(errors.New(string(b[1:])))
+	}
+	return nil
+}
+
+// Close closes ch, a stream that didn't end returns io.ErrUnexpectedEOF once
+// the chunks it received are read
+func (r *ReaderReceiver) Close() error {
+	r.once.Do(func() {
+		close(r.closed)
+		close(r.ch)
+	})
+	return nil
+}
+
+// streamReader is the io.ReadCloser of a stream
+type streamReader struct {
+	chunks chan []byte
+	// err is set before chunks is closed
+	err error
+	buf []byte
+
+	// closed once the ReaderReceiver is closed
+	receiverClosed chan struct{}
+	// done is closed by Close
+	done chan struct{}
+	once sync.Once
+}
+
+func newStreamReader(receiverClosed chan struct{}) *streamReader {
+	return &streamReader{
+		chunks:         make(chan []byte, chunkQueueSize),
+		receiverClosed: receiverClosed,
+		done:           make(chan struct{}),
+	}
+}
+
+// end ends the stream, Read returns err once the chunks are read
+func (s *streamReader) end(err error) {
+	s.err = err
+	close(s.chunks)
+}
+
+// Read reads the stream, returning io.EOF at its end or the sender's error
+func (s *streamReader) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		var chunk []byte
+		var ok bool
+		select {
+		case chunk, ok = <-s.chunks:
+		case <-s.receiverClosed:
+			// Read what arrived before the connection closed
+			select {
+			case chunk, ok = <-s.chunks:
+			default:
+				return 0, io.ErrUnexpectedEOF
+			}
+		}
+		if !ok {
+			return 0, s.err
+		}
+		s.buf = chunk
+	}
+
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// Close stops receiving the stream, the rest of it is dropped
+func (s *streamReader) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+	})
+	return nil
+}
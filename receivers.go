@@ -45,11 +45,21 @@ func (r *ValueReceiver) Receive(b []byte) error {
 	return nil
 }
 
+// ResetStream resets the decoder when the sender replaced its encoder
+func (r *ValueReceiver) ResetStream() {
+	ResetStream(r.dec)
+}
+
 func (r *ValueReceiver) Close() error {
 	r.ch.Close()
 	return nil
 }
 
+// ElemType returns the type of values put on ch
+func (r *ValueReceiver) ElemType() reflect.Type {
+	return r.t
+}
+
 // SignalReceiver receives signals
 type SignalReceiver struct {
 	dec BufferDecoder
@@ -78,12 +88,22 @@ func (r SignalReceiver) Receive(b []byte) error {
 	return nil
 }
 
+// ResetStream resets the decoder when the sender replaced its encoder
+func (r SignalReceiver) ResetStream() {
+	ResetStream(r.dec)
+}
+
 // Close and cleans up SignalReceiver
 func (r SignalReceiver) Close() error {
 	close(r.ch)
 	return nil
 }
 
+// ElemType returns syscall.Signal, which is decoded and put on ch
+func (r SignalReceiver) ElemType() reflect.Type {
+	return reflect.TypeOf(syscall.Signal(0))
+}
+
 // StringReceiver receives strings
 type StringReceiver struct {
 	dec BufferDecoder
@@ -112,8 +132,43 @@ func (r StringReceiver) Receive(b []byte) error {
 	return nil
 }
 
+// ResetStream resets the decoder when the sender replaced its encoder
+func (r StringReceiver) ResetStream() {
+	ResetStream(r.dec)
+}
+
 // Close and cleans up StringReceiver
 func (r StringReceiver) Close() error {
 	close(r.ch)
 	return nil
 }
+
+// ElemType returns string
+func (r StringReceiver) ElemType() reflect.Type {
+	return reflect.TypeOf("")
+}
+
+// BytesReceiver receives frame data as is
+type BytesReceiver struct {
+	ch chan []byte
+}
+
+// NewBytesReceiver returns a BytesReceiver, it doesn't decode frames so it
+// is used with SendRaw or a Pool that passes bytes through
+func NewBytesReceiver(ch chan []byte) BytesReceiver {
+	return BytesReceiver{
+		ch: ch,
+	}
+}
+
+// Receive puts b on ch, b isn't reused by the Conn
+func (r BytesReceiver) Receive(b []byte) error {
+	r.ch <- b
+	return nil
+}
+
+// Close and cleans up BytesReceiver
+func (r BytesReceiver) Close() error {
+	close(r.ch)
+	return nil
+}
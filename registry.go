@@ -0,0 +1,169 @@
+package mux
+
+import (
+	"errors"
+	"sync"
+)
+
+// errUnregistered is returned by a registered receiver once it's closing
+var errUnregistered = errors.New("Receiver unregistered")
+
+// registered is a Receiver in a registry, its Close is held back until any
+// Receive in flight returns
+type registered struct {
+	r Receiver
+
+	lock     sync.Mutex
+	inFlight int
+	closing  bool
+}
+
+// Receive hands b to the Receiver unless it's closing
+func (e *registered) Receive(b []byte) error {
+	e.lock.Lock()
+	if e.closing {
+		e.lock.Unlock()
+		return errUnregistered
+	}
+	e.inFlight++
+	e.lock.Unlock()
+
+	err := e.r.Receive(b)
+
+	e.lock.Lock()
+	e.inFlight--
+	closeNow := e.closing && e.inFlight == 0
+	e.lock.Unlock()
+	if closeNow {
+		e.r.Close()
+	}
+	return err
+}
+
+// close closes the Receiver now if it's idle, otherwise once the last
+// Receive in flight returns
+func (e *registered) close() {
+	e.lock.Lock()
+	if e.closing {
+		e.lock.Unlock()
+		return
+	}
+	e.closing = true
+	closeNow := e.inFlight == 0
+	e.lock.Unlock()
+	if closeNow {
+		e.r.Close()
+	}
+}
+
+// registry holds the Receivers of frame types, safe to change while Recv
+// is running
+type registry struct {
+	lock      sync.Mutex
+	receivers map[uint8]*registered
+}
+
+func newRegistry() *registry {
+	return &registry{
+		receivers: make(map[uint8]*registered),
+	}
+}
+
+// get returns the Receiver of t
+func (g *registry) get(t uint8) (*registered, bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	e, ok := g.receivers[t]
+	return e, ok
+}
+
+// set registers r for t, returning the Receiver it replaces if any
+func (g *registry) set(t uint8, r Receiver) *registered {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	old := g.receivers[t]
+	g.receivers[t] = &registered{r: r}
+	return old
+}
+
+// remove unregisters the Receiver of t, returning it if any
+func (g *registry) remove(t uint8) *registered {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	e := g.receivers[t]
+	delete(g.receivers, t)
+	return e
+}
+
+// snapshot returns the registered Receivers
+func (g *registry) snapshot() map[uint8]Receiver {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	receivers := make(map[uint8]Receiver, len(g.receivers))
+	for t, e := range g.receivers {
+		receivers[t] = e.r
+	}
+	return receivers
+}
+
+// closeAll closes every Receiver, they stay registered. They're closed
+// without holding the lock since Close may call back into the Conn.
+func (g *registry) closeAll() {
+	g.lock.Lock()
+	entries := make([]*registered, 0, len(g.receivers))
+	for _, e := range g.receivers {
+		entries = append(entries, e)
+	}
+	g.lock.Unlock()
+
+	for _, e := range entries {
+		e.close()
+	}
+}
+
+// Receive registers a receiver to receive t, replacing any without closing
+// it. It panics if r is an ElemTyper delivering a type other than the one
+// declared in the Schema.
+func (c *conn) Receive(t uint8, r Receiver) {
+	c.checkReceiver(t, r)
+	c.receivers.set(t, r)
+	c.lgr.Debugf("Added receiver type %d\n", t)
+}
+
+// Replace registers r to receive t, closing the receiver it replaces once
+// any Receive in flight returns. r decodes with its own decoder, so with a
+// stateful Pool such as gob's without SelfDescribing it misses the type
+// information already sent on t and fails to decode. Use a self-describing
+// Pool for types whose receivers are replaced.
+func (c *conn) Replace(t uint8, r Receiver) {
+	c.checkReceiver(t, r)
+	if old := c.receivers.set(t, r); old != nil {
+		old.close()
+	}
+	c.lgr.Debugf("Replaced receiver type %d\n", t)
+}
+
+// Unregister stops receiving t, closing its receiver once any Receive in
+// flight returns. Frames of t are dropped from then on.
+func (c *conn) Unregister(t uint8) {
+	if old := c.receivers.remove(t); old != nil {
+		old.close()
+	}
+	c.lgr.Debugf("Removed receiver type %d\n", t)
+}
+
+// Receivers returns the registered receivers by type
+func (c *conn) Receivers() map[uint8]Receiver {
+	return c.receivers.snapshot()
+}
+
+// checkReceiver panics if r delivers a type other than the one declared for
+// t in the Schema
+func (c *conn) checkReceiver(t uint8, r Receiver) {
+	if c.schema == nil {
+		return
+	}
+	if err := c.schema.checkReceiver(t, r); err != nil {
+		panic(err.Error())
+	}
+}
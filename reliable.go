@@ -0,0 +1,366 @@
+package mux
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Frames of reliable types are flagged with flagReliable and their data
+// starts with the sending Reliable's instance, the frame's ID and the lowest
+// ID not yet acknowledged when it was sent:
+//
+//	[instance uint64][id uint64][floor uint64][data]
+//
+// The receiver acknowledges the ID on AckType once the Receiver returned nil.
+// IDs below the floor were received or never sent, so the receiver stops
+// tracking them. IDs are only unique to an instance, a new one means the peer
+// started over.
+
+const (
+	// flagReliable marks a frame that is acknowledged
+	flagReliable uint8 = 1 << 3
+
+	// reliableHeaderLen is the length of the instance, ID and floor
+	reliableHeaderLen = 24
+
+	// DefaultRedeliveryTimeout is used when NewReliable isn't given a timeout
+	DefaultRedeliveryTimeout = 5 * time.Second
+)
+
+var (
+	// ErrNotReliable is returned by SendReliable for a type that isn't
+	// reliable
+	ErrNotReliable = errors.New("Type not reliable")
+	// ErrReliableUnsupported is returned when sending a reliable type to a
+	// peer that doesn't acknowledge frames
+	ErrReliableUnsupported = errors.New("Reliable delivery unsupported by peer")
+)
+
+// Delivery is a frame of a reliable type, sent until the peer acknowledges it
+type Delivery struct {
+	id    uint64
+	t     uint8
+	data  []byte
+	acked chan struct{}
+
+	// sent is when it was last sent, guarded by the Reliable
+	sent time.Time
+}
+
+// ID returns the ID of the frame
+func (d *Delivery) ID() uint64 {
+	return d.id
+}
+
+// Acked is closed once the peer acknowledged the frame
+func (d *Delivery) Acked() <-chan struct{} {
+	return d.acked
+}
+
+// Wait waits for the peer to acknowledge the frame until ctx is done. It
+// keeps waiting if the connection drops, as the frame is redelivered on the
+// next Conn using the Reliable.
+func (d *Delivery) Wait(ctx context.Context) error {
+	select {
+	case <-d.acked:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reliable delivers the frames of its types at least once. The peer
+// acknowledges a frame once its Receiver returns nil, frames it doesn't
+// acknowledge within the timeout are sent again and it drops duplicates.
+//
+// A Reliable is used by one Conn at a time, passing it to the Config of the
+// Conn replacing a dropped one redelivers the frames that weren't
+// acknowledged and keeps dropping duplicates. Frames are redelivered as they
+// were encoded, so stateful encodings such as gob must be self-describing.
+type Reliable struct {
+	timeout  time.Duration
+	instance uint64
+
+	lock    sync.Mutex
+	types   map[uint8]bool
+	nextID  uint64
+	pending map[uint64]*Delivery
+
+	// every ID from the peer's instance up to received was received, and
+	// those in above
+	peer     uint64
+	received uint64
+	above    map[uint64]struct{}
+}
+
+// NewReliable creates a Reliable redelivering frames that aren't acknowledged
+// within timeout, zero uses DefaultRedeliveryTimeout
+func NewReliable(timeout time.Duration) *Reliable {
+	if timeout <= 0 {
+		timeout = DefaultRedeliveryTimeout
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return &Reliable{
+		timeout:  timeout,
+		instance: binary.BigEndian.Uint64(b),
+		types:    make(map[uint8]bool),
+		pending:  make(map[uint64]*Delivery),
+		above:    make(map[uint64]struct{}),
+	}
+}
+
+// Add makes frames of t reliable
+func (r *Reliable) Add(t uint8) error {
+	if reserved(t) {
+		return ErrReservedType
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.types[t] = true
+	return nil
+}
+
+// has returns whether frames of t are reliable
+func (r *Reliable) has(t uint8) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.types[t]
+}
+
+// sends returns whether there are reliable types to send
+func (r *Reliable) sends() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.types) > 0
+}
+
+// track returns a Delivery for b, the encoded frame data
+func (r *Reliable) track(t uint8, b []byte) *Delivery {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.nextID++
+	floor := r.nextID
+	for id := range r.pending {
+		if id < floor {
+			floor = id
+		}
+	}
+	data := make([]byte, reliableHeaderLen, reliableHeaderLen+len(b))
+	binary.BigEndian.PutUint64(data, r.instance)
+	binary.BigEndian.PutUint64(data[8:], r.nextID)
+	binary.BigEndian.PutUint64(data[16:], floor)
+	d := &Delivery{
+		id:    r.nextID,
+		t:     t,
+		data:  append(data, b...),
+		acked: make(chan struct{}),
+		sent:  time.Now(),
+	}
+	r.pending[d.id] = d
+	return d
+}
+
+// forget stops tracking a Delivery that was never sent, later frames' floors
+// tell the peer to skip its ID
+func (r *Reliable) forget(d *Delivery) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.pending, d.id)
+}
+
+// ack marks the frame with id acknowledged
+func (r *Reliable) ack(id uint64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if d, ok := r.pending[id]; ok {
+		delete(r.pending, id)
+		close(d.acked)
+	}
+}
+
+// due returns the frames to send again in order, all of them if all is set
+func (r *Reliable) due(all bool) []*Delivery {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	var due []*Delivery
+	for _, d := range r.pending {
+		if all || now.Sub(d.sent) >= r.timeout {
+			d.sent = now
+			due = append(due, d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].id < due[j].id
+	})
+	return due
+}
+
+// seen returns whether the frame with id from instance was received
+func (r *Reliable) seen(instance, id uint64) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if instance != r.peer {
+		return false
+	}
+	_, ok := r.above[id]
+	return id <= r.received || ok
+}
+
+// receive records receiving the frame with id from instance, whose IDs below
+// floor are done with
+func (r *Reliable) receive(instance, id, floor uint64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if instance != r.peer {
+		r.peer = instance
+		r.received = 0
+		r.above = make(map[uint64]struct{})
+	}
+
+	if floor > r.received+1 {
+		r.received = floor - 1
+		for above := range r.above {
+			if above <= r.received {
+				delete(r.above, above)
+			}
+		}
+	}
+	if id > r.received {
+		r.above[id] = struct{}{}
+	}
+	for {
+		if _, ok := r.above[r.received+1]; !ok {
+			return
+		}
+		r.received++
+		delete(r.above, r.received)
+	}
+}
+
+// SendReliable sends e on t like SendContext, returning the Delivery that
+// tracks the frame until the peer acknowledges it
+func (c *conn) SendReliable(ctx context.Context, t uint8, e interface{}) (*Delivery, error) {
+	if !c.reliable.has(t) {
+		return nil, ErrNotReliable
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f, d, err := c.queueSend(ctx, t, e)
+	if err != nil {
+		return nil, err
+	}
+	return d, c.wait(ctx, f)
+}
+
+// checkReliable waits for the hello and checks the peer acknowledges frames
+func (c *conn) checkReliable(ctx context.Context) error {
+	caps, err := c.waitHello(ctx)
+	if err != nil {
+		return err
+	}
+	if !caps.Has(CapReliable) {
+		return ErrReliableUnsupported
+	}
+	return nil
+}
+
+// startRedelivery starts redelivering frames the first time Recv is called,
+// since acknowledgements can't arrive without it
+func (c *conn) startRedelivery() {
+	if !c.reliable.sends() {
+		return
+	}
+	c.redeliverStart.Do(func() {
+		go c.redeliver()
+	})
+}
+
+// redeliver sends the frames the peer didn't acknowledge, first those from
+// earlier Conns and then those timing out, until the connection shuts down.
+// It stops if the peer doesn't support CapReliable.
+func (c *conn) redeliver() {
+	caps, err := c.waitHello(context.Background())
+	if err != nil || !caps.Has(CapReliable) {
+		return
+	}
+
+	for _, d := range c.reliable.due(true) {
+		c.resend(d)
+	}
+
+	ticker := time.NewTicker(c.reliable.timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, d := range c.reliable.due(false) {
+				c.resend(d)
+			}
+		case <-c.ShutdownCh:
+			return
+		}
+	}
+}
+
+// resend queues d again without waiting for it to be written
+func (c *conn) resend(d *Delivery) {
+	if !c.goAway.beginSend() {
+		return
+	}
+	enc := c.sendEncoder(d.t)
+	enc.Lock()
+	defer enc.Unlock()
+	if _, err := c.queueMessage(context.Background(), d.t, flagReliable, d.data, false); err != nil {
+		c.lgr.Debugf("Unable to redeliver frame %d: %s", d.id, err)
+	}
+}
+
+// recvReliable hands a reliable frame to r unless it's a duplicate, and
+// acknowledges it once r returns nil
+func (c *conn) recvReliable(f Frame, r *registered) {
+	if len(f.Data) < reliableHeaderLen {
+		c.lgr.Warnf("dropping frame %d, too short to be reliable\n", f.Type)
+		return
+	}
+	instance, id, floor := reliableHeader(f.Data)
+
+	if !c.reliable.seen(instance, id) {
+		if err := r.Receive(f.Data[reliableHeaderLen:]); err != nil {
+			c.lgr.Errorf("Receive error %s while receiving frame %d, it will be redelivered", err, id)
+			return
+		}
+		c.reliable.receive(instance, id, floor)
+	}
+
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, id)
+	c.queueFrame(Frame{
+		Type: AckType,
+		Data: b,
+	})
+}
+
+// reliableHeader returns the instance, ID and floor b starts with
+func reliableHeader(b []byte) (instance, id, floor uint64) {
+	return binary.BigEndian.Uint64(b), binary.BigEndian.Uint64(b[8:]), binary.BigEndian.Uint64(b[16:])
+}
+
+// recvAck handles an acknowledgement
+func (c *conn) recvAck(b []byte) {
+	if len(b) != 8 {
+		c.lgr.Warnf("dropping invalid acknowledgement\n")
+		return
+	}
+	c.reliable.ack(binary.BigEndian.Uint64(b))
+}
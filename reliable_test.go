@@ -0,0 +1,43 @@
+package mux
+
+import "testing"
+
+func TestReliableFloor(t *testing.T) {
+	sender := NewReliable(0)
+	receiver := NewReliable(0)
+	deliver := func(d *Delivery) {
+		instance, id, floor := reliableHeader(d.data)
+		if receiver.seen(instance, id) {
+			t.Fatalf("%d seen before it was delivered", id)
+		}
+		receiver.receive(instance, id, floor)
+		sender.ack(id)
+	}
+
+	deliver(sender.track(LogType, []byte("first")))
+
+	// Never sent, later floors move the receiver past it
+	sender.forget(sender.track(LogType, []byte("failed")))
+
+	// Delivered out of order
+	late := sender.track(LogType, []byte("late"))
+	for i := 0; i < 10; i++ {
+		deliver(sender.track(LogType, []byte("hello")))
+	}
+	if len(receiver.above) == 0 {
+		t.Fatal("expected IDs above the unacknowledged frame")
+	}
+	deliver(late)
+	deliver(sender.track(LogType, []byte("last")))
+
+	if len(receiver.above) != 0 {
+		t.Fatalf("expected no IDs above %d, got %d", receiver.received, len(receiver.above))
+	}
+	if receiver.received != sender.nextID {
+		t.Fatalf("expected %d received, got %d", sender.nextID, receiver.received)
+	}
+	instance, id, _ := reliableHeader(late.data)
+	if !receiver.seen(instance, id) {
+		t.Fatal("expected a delivered frame to be seen")
+	}
+}
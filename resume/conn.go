@@ -0,0 +1,537 @@
+package resume
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Records
+//
+// After the handshake everything is sent as records:
+//
+//	[recordData][seq uint64][length uint32][data]
+//	[recordAck][seq uint64]
+//	[recordClose]
+//
+// Data records are numbered from 1 in each direction and kept for replay
+// until the peer acknowledges them. A peer acknowledges the last record it
+// received once it has read up to it, so the replay buffer also bounds how
+// much the peer buffers. Records replayed after resuming that were already
+// received are dropped, so data is read in order and exactly once. A close
+// record ends the session.
+
+const (
+	recordData  uint8 = 0
+	recordAck   uint8 = 1
+	recordClose uint8 = 2
+
+	dataHeaderLen = 1 + 8 + 4
+	ackLen        = 1 + 8
+
+	// maxRecordData is the most data sent in a record
+	maxRecordData = 32 * 1024
+	// ackBytes is how much is read before acknowledging it even though more
+	// is buffered
+	ackBytes = 64 * 1024
+	// closeTimeout bounds writing the close record
+	closeTimeout = time.Second
+)
+
+var (
+	// ErrResumeTimeout is returned once a dropped session isn't resumed
+	// within the Config's ResumeTimeout, a mux.Conn reading it shuts down
+	// with this error
+	ErrResumeTimeout = errors.New("resume: session not resumed in time")
+	// ErrInvalidRecord is returned when the peer sends a record out of
+	// sequence or of an unknown type
+	ErrInvalidRecord = errors.New("resume: invalid record")
+
+	// errClosed is returned once the Conn is closed, like a closed net.Conn
+	errClosed = &net.OpError{Op: "use", Net: "resume", Err: net.ErrClosed}
+)
+
+// record is a data record kept for replay
+type record struct {
+	seq uint64
+	b   []byte
+}
+
+// Conn is a net.Conn that survives its underlying connection dropping. The
+// client redials and both sides replay what the other didn't receive.
+type Conn struct {
+	token    [tokenLen]byte
+	isClient bool
+	dial     func() (net.Conn, error)
+	config   *Config
+	// onClose is called once the session ends
+	onClose func()
+
+	// writeLock keeps records in sequence on the connection
+	writeLock sync.Mutex
+
+	lock sync.Mutex
+	// conn is nil while the session is dropped, attached counts the
+	// connections the session was on
+	conn       net.Conn
+	attached   int
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	// changed is closed and replaced when the state changes
+	changed chan struct{}
+	// done is closed once the session ends
+	done chan struct{}
+	err  error
+
+	// sent is the last data record written, unacked those the peer didn't
+	// acknowledge yet
+	sent         uint64
+	unacked      []record
+	unackedBytes int
+
+	// received is the last data record received, acked the last one
+	// acknowledged
+	received  uint64
+	acked     uint64
+	in        bytes.Buffer
+	readSince int
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func newConn(token [tokenLen]byte, isClient bool, config *Config) *Conn {
+	return &Conn{
+		token:    token,
+		isClient: isClient,
+		config:   config,
+		onClose:  func() {},
+		changed:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// notify wakes up everyone waiting for a change, the caller holds lock
+func (c *Conn) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// wait waits for a change until deadline, returning false if it passed. The
+// caller holds lock, which is released while waiting.
+func (c *Conn) wait(deadline time.Time) bool {
+	changed := c.changed
+	c.lock.Unlock()
+	defer c.lock.Lock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return false
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-changed:
+		return true
+	case <-timeout:
+		return false
+	}
+}
+
+// Read reads data from the session, waiting while it's dropped
+func (c *Conn) Read(p []byte) (int, error) {
+	c.lock.Lock()
+	for c.in.Len() == 0 {
+		if c.err != nil {
+			c.lock.Unlock()
+			return 0, c.err
+		}
+		if !c.wait(c.readDeadline) {
+			c.lock.Unlock()
+			return 0, &net.OpError{Op: "read", Net: "resume", Err: os.ErrDeadlineExceeded}
+		}
+	}
+
+	n, _ := c.in.Read(p)
+	c.readSince += n
+	ack := c.received > c.acked && (c.in.Len() == 0 || c.readSince >= ackBytes)
+	if ack {
+		c.acked = c.received
+		c.readSince = 0
+	}
+	seq := c.acked
+	c.lock.Unlock()
+
+	if ack {
+		b := make([]byte, ackLen)
+		b[0] = recordAck
+		binary.BigEndian.PutUint64(b[1:], seq)
+		c.writeRecord(b)
+	}
+	return n, nil
+}
+
+// Write writes p as data records, which are kept until the peer acknowledges
+// them. It only waits while the replay buffer is full, so it succeeds while
+// the session is dropped.
+func (c *Conn) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		data := p[:min(len(p), maxRecordData)]
+		if err := c.waitRoom(len(data)); err != nil {
+			return n, err
+		}
+
+		c.writeLock.Lock()
+		c.lock.Lock()
+		c.sent++
+		b := make([]byte, dataHeaderLen, dataHeaderLen+len(data))
+		b[0] = recordData
+		binary.BigEndian.PutUint64(b[1:], c.sent)
+		binary.BigEndian.PutUint32(b[9:], uint32(len(data)))
+		b = append(b, data...)
+		c.unacked = append(c.unacked, record{seq: c.sent, b: b})
+		c.unackedBytes += len(data)
+		conn := c.conn
+		c.lock.Unlock()
+
+		if conn != nil {
+			if _, err := conn.Write(b); err != nil {
+				// It's replayed once the session resumes
+				c.dropped(conn, err)
+			}
+		}
+		c.writeLock.Unlock()
+
+		n += len(data)
+		p = p[len(data):]
+	}
+	return n, nil
+}
+
+// waitRoom waits for room for n bytes in the replay buffer
+func (c *Conn) waitRoom(n int) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for {
+		switch {
+		case c.err == io.EOF:
+			return io.ErrClosedPipe
+		case c.err != nil:
+			return c.err
+		case c.unackedBytes == 0 || c.unackedBytes+n <= c.config.replayBufferSize():
+			return nil
+		}
+		if !c.wait(c.writeDeadline) {
+			return &net.OpError{Op: "write", Net: "resume", Err: os.ErrDeadlineExceeded}
+		}
+	}
+}
+
+// writeRecord writes b to the connection if there is one
+func (c *Conn) writeRecord(b []byte) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	c.lock.Lock()
+	conn := c.conn
+	c.lock.Unlock()
+	if conn == nil {
+		return
+	}
+	if _, err := conn.Write(b); err != nil {
+		c.dropped(conn, err)
+	}
+}
+
+// attach resumes the session on conn once the peer received up to
+// peerReceived. reply, if set, is written first with what we received.
+func (c *Conn) attach(conn net.Conn, peerReceived uint64, reply func(received uint64) error) error {
+	c.lock.Lock()
+	if old := c.conn; old != nil {
+		// The peer noticed the drop first, a Write may be stuck on old
+		c.conn = nil
+		old.Close()
+	}
+	c.lock.Unlock()
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	c.lock.Lock()
+	if c.err != nil {
+		c.lock.Unlock()
+		return c.err
+	}
+	if err := c.ack(peerReceived); err != nil {
+		c.lock.Unlock()
+		c.end(err)
+		return err
+	}
+	c.conn = conn
+	c.attached++
+	c.localAddr = conn.LocalAddr()
+	c.remoteAddr = conn.RemoteAddr()
+	received := c.received
+	c.acked = received
+	c.readSince = 0
+	replay := append([]record(nil), c.unacked...)
+	c.notify()
+	c.lock.Unlock()
+
+	go c.readLoop(conn)
+
+	if reply != nil {
+		if err := reply(received); err != nil {
+			c.dropped(conn, err)
+			return nil
+		}
+	}
+	for _, r := range replay {
+		if _, err := conn.Write(r.b); err != nil {
+			c.dropped(conn, err)
+			return nil
+		}
+	}
+	return nil
+}
+
+// ack drops the records the peer received from the replay buffer, the
+// caller holds lock
+func (c *Conn) ack(seq uint64) error {
+	if seq > c.sent {
+		return ErrInvalidRecord
+	}
+	i := 0
+	for ; i < len(c.unacked) && c.unacked[i].seq <= seq; i++ {
+		c.unackedBytes -= len(c.unacked[i].b) - dataHeaderLen
+		c.unacked[i] = record{}
+	}
+	if i > 0 {
+		c.unacked = c.unacked[i:]
+		c.notify()
+	}
+	return nil
+}
+
+// readLoop reads records from conn until it fails
+func (c *Conn) readLoop(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		if err := c.readRecord(r); err != nil {
+			c.dropped(conn, err)
+			return
+		}
+	}
+}
+
+// readRecord reads and handles the next record
+func (c *Conn) readRecord(r *bufio.Reader) error {
+	t, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	switch t {
+	case recordData:
+		header := make([]byte, dataHeaderLen-1)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return err
+		}
+		seq := binary.BigEndian.Uint64(header)
+		n := binary.BigEndian.Uint32(header[8:])
+		if n > maxRecordData {
+			return ErrInvalidRecord
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		switch {
+		case seq <= c.received:
+			// Replayed, but we got it before the drop
+		case seq == c.received+1:
+			c.in.Write(data)
+			c.received = seq
+			c.notify()
+		default:
+			return ErrInvalidRecord
+		}
+	case recordAck:
+		b := make([]byte, ackLen-1)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return err
+		}
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		return c.ack(binary.BigEndian.Uint64(b))
+	case recordClose:
+		c.end(io.EOF)
+		return io.EOF
+	default:
+		return ErrInvalidRecord
+	}
+	return nil
+}
+
+// dropped handles conn failing with err. Unless the session is over the
+// client redials and the server waits for it to.
+func (c *Conn) dropped(conn net.Conn, err error) {
+	conn.Close()
+	if err == ErrInvalidRecord {
+		c.end(err)
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.conn != conn || c.err != nil {
+		return
+	}
+	c.conn = nil
+	c.notify()
+
+	if c.isClient {
+		go c.redial()
+	} else {
+		attached := c.attached
+		time.AfterFunc(c.config.resumeTimeout(), func() {
+			c.lock.Lock()
+			expired := c.attached == attached
+			c.lock.Unlock()
+			if expired {
+				c.end(ErrResumeTimeout)
+			}
+		})
+	}
+}
+
+// redial dials until the session resumes or ResumeTimeout passes
+func (c *Conn) redial() {
+	deadline := time.Now().Add(c.config.resumeTimeout())
+	interval := c.config.redialInterval()
+	for {
+		conn, err := c.dial()
+		if err == nil {
+			err = c.resume(conn)
+			if err == nil {
+				return
+			}
+			conn.Close()
+			if err == ErrSessionExpired {
+				c.end(err)
+				return
+			}
+		}
+
+		wait := min(interval, time.Until(deadline))
+		if wait <= 0 {
+			c.end(ErrResumeTimeout)
+			return
+		}
+		select {
+		case <-time.After(wait):
+		case <-c.done:
+			return
+		}
+		interval = min(2*interval, maxRedialInterval)
+	}
+}
+
+// end ends the session with err
+func (c *Conn) end(err error) {
+	c.lock.Lock()
+	if c.err != nil {
+		c.lock.Unlock()
+		return
+	}
+	c.err = err
+	conn := c.conn
+	c.conn = nil
+	close(c.done)
+	c.notify()
+	c.lock.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+	c.onClose()
+}
+
+// Close ends the session, telling the peer so it doesn't wait for it to
+// resume
+func (c *Conn) Close() error {
+	c.lock.Lock()
+	if c.err != nil {
+		c.lock.Unlock()
+		return nil
+	}
+	conn := c.conn
+	c.lock.Unlock()
+
+	// Unless a Write is stuck on conn
+	if conn != nil && c.writeLock.TryLock() {
+		conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+		conn.Write([]byte{recordClose})
+		c.writeLock.Unlock()
+	}
+	c.end(errClosed)
+	return nil
+}
+
+// LocalAddr returns the local address of the latest connection
+func (c *Conn) LocalAddr() net.Addr {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.localAddr
+}
+
+// RemoteAddr returns the remote address of the latest connection
+func (c *Conn) RemoteAddr() net.Addr {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.remoteAddr
+}
+
+// SetDeadline sets the read and write deadlines
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.readDeadline = t
+	c.writeDeadline = t
+	c.notify()
+	return nil
+}
+
+// SetReadDeadline sets the deadline for Read
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.readDeadline = t
+	c.notify()
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for Write, which only waits while the
+// replay buffer is full
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.writeDeadline = t
+	c.notify()
+	return nil
+}
@@ -0,0 +1,379 @@
+// Package resume keeps a session going when the connection under it drops.
+// A Conn is a net.Conn, so a mux.Conn on top of it and its receivers never
+// notice the client redialing.
+package resume
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// The handshake
+//
+// The client starts each connection with a hello holding the session's
+// resume token, zero for a new session, and the last data record it
+// received:
+//
+//	["MUXR"][version uint8][token 16 bytes][received uint64]
+//
+// The server replies with whether it knows the session, the token and the
+// last data record it received:
+//
+//	["MUXR"][version uint8][status uint8][token 16 bytes][received uint64]
+//
+// Each side then replays the records the other didn't receive.
+
+const (
+	// version of the resume protocol
+	version uint8 = 1
+
+	tokenLen       = 16
+	clientHelloLen = 4 + 1 + tokenLen + 8
+	serverHelloLen = 4 + 1 + 1 + tokenLen + 8
+
+	statusOK      uint8 = 0
+	statusExpired uint8 = 1
+
+	// DefaultReplayBufferSize is used when the Config doesn't specify
+	// ReplayBufferSize
+	DefaultReplayBufferSize = 4 * 1024 * 1024
+	// DefaultResumeTimeout is used when the Config doesn't specify
+	// ResumeTimeout
+	DefaultResumeTimeout = 30 * time.Second
+	// DefaultRedialInterval is used when the Config doesn't specify
+	// RedialInterval
+	DefaultRedialInterval = 100 * time.Millisecond
+	// DefaultHandshakeTimeout is used when the Config doesn't specify
+	// HandshakeTimeout
+	DefaultHandshakeTimeout = 10 * time.Second
+
+	// maxRedialInterval caps the doubling of RedialInterval
+	maxRedialInterval = 5 * time.Second
+)
+
+var (
+	magic = []byte("MUXR")
+
+	// ErrHandshake is returned when the peer fails the handshake
+	ErrHandshake = errors.New("resume: handshake failed")
+	// ErrSessionExpired is returned when the server no longer knows the
+	// session being resumed
+	ErrSessionExpired = errors.New("resume: session expired")
+	// ErrInvalidReplayBufferSize defines an error for an invalid Config
+	// ReplayBufferSize
+	ErrInvalidReplayBufferSize = errors.New("resume: invalid Config ReplayBufferSize")
+	// ErrInvalidResumeTimeout defines an error for an invalid Config
+	// ResumeTimeout
+	ErrInvalidResumeTimeout = errors.New("resume: invalid Config ResumeTimeout")
+	// ErrInvalidRedialInterval defines an error for an invalid Config
+	// RedialInterval
+	ErrInvalidRedialInterval = errors.New("resume: invalid Config RedialInterval")
+	// ErrInvalidHandshakeTimeout defines an error for an invalid Config
+	// HandshakeTimeout
+	ErrInvalidHandshakeTimeout = errors.New("resume: invalid Config HandshakeTimeout")
+)
+
+// Config configures a resumable session
+type Config struct {
+	// ReplayBufferSize bounds the data written that the peer hasn't
+	// acknowledged, Write waits once it's full. Zero uses
+	// DefaultReplayBufferSize.
+	ReplayBufferSize int
+
+	// ResumeTimeout is how long a dropped session has to resume before it
+	// fails with ErrResumeTimeout. Zero uses DefaultResumeTimeout.
+	ResumeTimeout time.Duration
+
+	// RedialInterval is the wait after a failed redial, doubling each time.
+	// Zero uses DefaultRedialInterval.
+	RedialInterval time.Duration
+
+	// HandshakeTimeout bounds each handshake, zero uses
+	// DefaultHandshakeTimeout
+	HandshakeTimeout time.Duration
+}
+
+// Verify verifies the Config
+func (c *Config) Verify() error {
+	if c.ReplayBufferSize < 0 || (c.ReplayBufferSize > 0 && c.ReplayBufferSize < maxRecordData) {
+		return ErrInvalidReplayBufferSize
+	}
+
+	if c.ResumeTimeout < 0 {
+		return ErrInvalidResumeTimeout
+	}
+
+	if c.RedialInterval < 0 {
+		return ErrInvalidRedialInterval
+	}
+
+	if c.HandshakeTimeout < 0 {
+		return ErrInvalidHandshakeTimeout
+	}
+
+	return nil
+}
+
+func (c *Config) replayBufferSize() int {
+	if c.ReplayBufferSize == 0 {
+		return DefaultReplayBufferSize
+	}
+	return c.ReplayBufferSize
+}
+
+func (c *Config) resumeTimeout() time.Duration {
+	if c.ResumeTimeout == 0 {
+		return DefaultResumeTimeout
+	}
+	return c.ResumeTimeout
+}
+
+func (c *Config) redialInterval() time.Duration {
+	if c.RedialInterval == 0 {
+		return DefaultRedialInterval
+	}
+	return c.RedialInterval
+}
+
+func (c *Config) handshakeTimeout() time.Duration {
+	if c.HandshakeTimeout == 0 {
+		return DefaultHandshakeTimeout
+	}
+	return c.HandshakeTimeout
+}
+
+// Dial starts a session on a connection from dial, which is called again to
+// resume the session whenever the connection drops
+func Dial(dial func() (net.Conn, error), config *Config) (*Conn, error) {
+	if err := config.Verify(); err != nil {
+		return nil, err
+	}
+
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	token, received, err := clientHandshake(conn, [tokenLen]byte{}, 0, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := newConn(token, true, config)
+	c.dial = dial
+	if err := c.attach(conn, received, nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// resume resumes the session on conn
+func (c *Conn) resume(conn net.Conn) error {
+	c.lock.Lock()
+	received := c.received
+	c.lock.Unlock()
+
+	_, peerReceived, err := clientHandshake(conn, c.token, received, c.config)
+	if err != nil {
+		return err
+	}
+	return c.attach(conn, peerReceived, nil)
+}
+
+// clientHandshake sends the client hello and reads the server's, returning
+// the session's token and the last record the server received
+func clientHandshake(conn net.Conn, token [tokenLen]byte, received uint64, config *Config) ([tokenLen]byte, uint64, error) {
+	conn.SetDeadline(time.Now().Add(config.handshakeTimeout()))
+	defer conn.SetDeadline(time.Time{})
+
+	hello := make([]byte, 0, clientHelloLen)
+	hello = append(hello, magic...)
+	hello = append(hello, version)
+	hello = append(hello, token[:]...)
+	hello = binary.BigEndian.AppendUint64(hello, received)
+	if _, err := conn.Write(hello); err != nil {
+		return token, 0, err
+	}
+
+	reply := make([]byte, serverHelloLen)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return token, 0, err
+	}
+	if !bytes.Equal(reply[:len(magic)], magic) || reply[len(magic)] != version {
+		return token, 0, ErrHandshake
+	}
+	b := reply[len(magic)+1:]
+	switch b[0] {
+	case statusOK:
+	case statusExpired:
+		return token, 0, ErrSessionExpired
+	default:
+		return token, 0, ErrHandshake
+	}
+	copy(token[:], b[1:])
+	return token, binary.BigEndian.Uint64(b[1+tokenLen:]), nil
+}
+
+// Listener accepts new sessions and resumes dropped ones. Each connection's
+// handshake runs on its own, so a slow client doesn't hold up the others.
+type Listener struct {
+	net.Listener
+	config *Config
+
+	// accepted holds new sessions and errs errors from the net.Listener
+	// until Accept returns them
+	accepted  chan *Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+
+	lock     sync.Mutex
+	sessions map[[tokenLen]byte]*Conn
+}
+
+// NewListener creates a Listener accepting sessions on l
+func NewListener(l net.Listener, config *Config) (*Listener, error) {
+	if err := config.Verify(); err != nil {
+		return nil, err
+	}
+	rl := &Listener{
+		Listener: l,
+		config:   config,
+		accepted: make(chan *Conn),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+		sessions: make(map[[tokenLen]byte]*Conn),
+	}
+	go rl.serve()
+	return rl, nil
+}
+
+// Accept returns the next new session. Connections resuming a session are
+// handed to it instead, as are those failing the handshake closed.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accepted:
+		return c, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting sessions, those already accepted carry on
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return l.Listener.Close()
+}
+
+// serve accepts connections until the Listener is closed, passing errors on
+// to Accept
+func (l *Listener) serve() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+				continue
+			case <-l.done:
+				return
+			}
+		}
+		go l.serveConn(conn)
+	}
+}
+
+// serveConn runs the handshake on conn, handing a new session to Accept
+func (l *Listener) serveConn(conn net.Conn) {
+	c, err := l.handshake(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	if c == nil {
+		return
+	}
+	select {
+	case l.accepted <- c:
+	case <-l.done:
+		c.Close()
+	}
+}
+
+// handshake reads the client hello on conn, returning the new session it
+// starts. It returns nil if it resumes a session.
+func (l *Listener) handshake(conn net.Conn) (*Conn, error) {
+	conn.SetDeadline(time.Now().Add(l.config.handshakeTimeout()))
+	defer conn.SetDeadline(time.Time{})
+
+	hello := make([]byte, clientHelloLen)
+	if _, err := io.ReadFull(conn, hello); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(hello[:len(magic)], magic) || hello[len(magic)] != version {
+		return nil, ErrHandshake
+	}
+	var token [tokenLen]byte
+	copy(token[:], hello[len(magic)+1:])
+	peerReceived := binary.BigEndian.Uint64(hello[len(magic)+1+tokenLen:])
+
+	reply := func(status uint8, received uint64) error {
+		b := make([]byte, 0, serverHelloLen)
+		b = append(b, magic...)
+		b = append(b, version, status)
+		b = append(b, token[:]...)
+		b = binary.BigEndian.AppendUint64(b, received)
+		_, err := conn.Write(b)
+		return err
+	}
+
+	if token == ([tokenLen]byte{}) {
+		if _, err := rand.Read(token[:]); err != nil {
+			return nil, err
+		}
+		if err := reply(statusOK, 0); err != nil {
+			return nil, err
+		}
+		c := l.add(token)
+		if err := c.attach(conn, peerReceived, nil); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+
+	l.lock.Lock()
+	c, ok := l.sessions[token]
+	l.lock.Unlock()
+	if !ok {
+		reply(statusExpired, 0)
+		return nil, ErrSessionExpired
+	}
+	return nil, c.attach(conn, peerReceived, func(received uint64) error {
+		return reply(statusOK, received)
+	})
+}
+
+// add adds a new session, removed once it ends
+func (l *Listener) add(token [tokenLen]byte) *Conn {
+	c := newConn(token, false, l.config)
+	c.onClose = func() {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		delete(l.sessions, token)
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.sessions[token] = c
+	return c
+}
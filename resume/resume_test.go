@@ -0,0 +1,313 @@
+package resume
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/lager"
+	"github.com/doubledutch/mux"
+	"github.com/doubledutch/mux/gob"
+)
+
+// dropper dials addr and can drop the connection or fail to redial
+type dropper struct {
+	addr string
+
+	lock sync.Mutex
+	conn net.Conn
+	fail bool
+}
+
+func (d *dropper) dial() (net.Conn, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.fail {
+		return nil, errors.New("unreachable")
+	}
+	conn, err := net.Dial("tcp", d.addr)
+	d.conn = conn
+	return conn, err
+}
+
+func (d *dropper) drop() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.conn.Close()
+}
+
+func (d *dropper) setFail(fail bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.fail = fail
+}
+
+// sessionPair returns both ends of a session and the dropper under it
+func sessionPair(t *testing.T, clientConfig, serverConfig *Config) (*Conn, *Conn, *dropper) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl, err := NewListener(l, serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		rl.Close()
+	})
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := rl.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- conn
+	}()
+
+	d := &dropper{addr: l.Addr().String()}
+	client, err := Dial(d.dial, clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, (<-accepted).(*Conn), d
+}
+
+func muxConfig() *mux.Config {
+	return &mux.Config{
+		Timeout: 100 * time.Millisecond,
+		Lager: lager.NewLogLager(&lager.LogConfig{
+			Levels: lager.LevelsFromString(os.Getenv("LOG_LEVELS")),
+			Output: os.Stderr,
+		}),
+	}
+}
+
+func TestResume(t *testing.T) {
+	clientConn, serverConn, d := sessionPair(t, &Config{}, &Config{})
+	pool := new(gob.Pool)
+	client, err := mux.NewConn(clientConn, pool, muxConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+	server, err := mux.NewConn(serverConn, pool, muxConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Shutdown()
+
+	// The server echoes what it receives
+	serverCh := make(chan int, 10)
+	server.Receive(10, pool.NewReceiver(serverCh))
+	clientCh := make(chan int, 10)
+	client.Receive(11, pool.NewReceiver(clientCh))
+	go client.Recv()
+	go server.Recv()
+	go func() {
+		for i := range serverCh {
+			if err := server.Send(11, i); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	const n = 1000
+	go func() {
+		for i := 1; i <= n; i++ {
+			if err := client.Send(10, i); err != nil {
+				t.Error(err)
+				return
+			}
+			if i%100 == 0 {
+				d.drop()
+			}
+		}
+	}()
+
+	for i := 1; i <= n; i++ {
+		select {
+		case actual := <-clientCh:
+			if actual != i {
+				t.Fatalf("expected %d, got %d", i, actual)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d not received", i)
+		}
+	}
+}
+
+func TestResumeTimeout(t *testing.T) {
+	config := &Config{
+		ResumeTimeout:  200 * time.Millisecond,
+		RedialInterval: 10 * time.Millisecond,
+	}
+	clientConn, serverConn, d := sessionPair(t, config, config)
+	defer serverConn.Close()
+
+	pool := new(gob.Pool)
+	conn, err := mux.NewConn(clientConn, pool, muxConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := mux.NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go client.Recv()
+
+	d.setFail(true)
+	d.drop()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Wait()
+	}()
+	select {
+	case err := <-done:
+		if err != ErrResumeTimeout {
+			t.Fatalf("expected %s, got %v", ErrResumeTimeout, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Wait blocked")
+	}
+
+	if _, err := serverConn.Read(make([]byte, 1)); err != ErrResumeTimeout {
+		t.Fatalf("expected %s, got %v", ErrResumeTimeout, err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	client, server, _ := sessionPair(t, &Config{}, &Config{})
+
+	if _, err := client.Write([]byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := io.ReadAll(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello world" {
+		t.Fatalf("'%s' != 'hello world'", b)
+	}
+	if _, err := server.Write([]byte("hello world")); err != io.ErrClosedPipe {
+		t.Fatalf("expected %s, got %v", io.ErrClosedPipe, err)
+	}
+	if _, err := client.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected Read to fail once closed")
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	client, server, d := sessionPair(t, &Config{
+		RedialInterval: 10 * time.Millisecond,
+	}, &Config{
+		ResumeTimeout: 50 * time.Millisecond,
+	})
+
+	// Writes succeed while dropped
+	d.setFail(true)
+	d.drop()
+	if _, err := client.Write([]byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Read(make([]byte, 1)); err != ErrResumeTimeout {
+		t.Fatalf("expected %s, got %v", ErrResumeTimeout, err)
+	}
+
+	d.setFail(false)
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != ErrSessionExpired {
+		t.Fatalf("expected %s, got %v", ErrSessionExpired, err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	client, server, d := sessionPair(t, &Config{
+		RedialInterval: 10 * time.Millisecond,
+	}, &Config{})
+	defer client.Close()
+	defer server.Close()
+
+	// Written while the client is dropped and can't redial
+	d.setFail(true)
+	d.drop()
+	if _, err := client.Write([]byte("hello ")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Write([]byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	d.setFail(false)
+
+	b := make([]byte, len("hello world"))
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(server, b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello world" {
+		t.Fatalf("'%s' != 'hello world'", b)
+	}
+}
+
+func TestSlowHandshake(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl, err := NewListener(l, &Config{
+		HandshakeTimeout: 2 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+
+	// A client that never sends its hello doesn't hold up the others
+	idle, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := rl.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- conn
+	}()
+	start := time.Now()
+	client, err := Dial(func() (net.Conn, error) {
+		return net.Dial("tcp", l.Addr().String())
+	}, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	select {
+	case conn := <-accepted:
+		defer conn.Close()
+	case <-time.After(time.Second):
+		t.Fatal("session not accepted")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("session started after %s", elapsed)
+	}
+
+	// Accept fails once closed
+	rl.Close()
+	if _, err := rl.Accept(); err == nil {
+		t.Fatal("expected Accept to fail once closed")
+	}
+}
@@ -0,0 +1,149 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/doubledutch/mux"
+)
+
+// Client calls methods on a Server over a mux.Conn
+type Client struct {
+	conn mux.Conn
+	t    uint8
+
+	lock     sync.Mutex
+	seq      uint64
+	pending  map[uint64]chan *response
+	shutdown bool
+}
+
+// NewClient creates a Client sending requests on t and registers it to
+// receive responses on t
+func NewClient(conn mux.Conn, t uint8) *Client {
+	c := &Client{
+		conn:    conn,
+		t:       t,
+		pending: make(map[uint64]chan *response),
+	}
+
+	conn.Receive(t, &clientReceiver{
+		client: c,
+		dec:    conn.Pool().NewBufferDecoder(),
+	})
+
+	return c
+}
+
+// Call calls method with args and decodes the result into reply. It returns
+// ctx.Err() if ctx is done before the Server responds, in which case the
+// Server is asked to cancel the call. An error returned by the handler is
+// returned as a ServerError.
+func (c *Client) Call(ctx context.Context, method string, args interface{}, reply interface{}) error {
+	b, err := encode(c.conn.Pool(), args)
+	if err != nil {
+		return err
+	}
+
+	ch := make(chan *response, 1)
+
+	c.lock.Lock()
+	if c.shutdown {
+		c.lock.Unlock()
+		return ErrShutdown
+	}
+	c.seq++
+	id := c.seq
+	c.pending[id] = ch
+	c.lock.Unlock()
+
+	err = c.conn.SendContext(ctx, c.t, request{
+		ID:     id,
+		Method: method,
+		Args:   b,
+	})
+	if err != nil {
+		c.remove(id)
+		return err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return ErrShutdown
+		}
+		if resp.Error != "" {
+			return ServerError(resp.Error)
+		}
+		return decode(c.conn.Pool(), resp.Reply, reply)
+	case <-ctx.Done():
+		if c.remove(id) {
+			c.conn.Send(c.t, request{
+				ID:     id,
+				Cancel: true,
+			})
+		}
+		return ctx.Err()
+	}
+}
+
+// remove stops waiting for id, returning false if it already completed
+func (c *Client) remove(id uint64) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, ok := c.pending[id]
+	delete(c.pending, id)
+	return ok
+}
+
+// clientReceiver receives responses for a Client
+type clientReceiver struct {
+	client *Client
+	dec    mux.BufferDecoder
+}
+
+// Receive decodes a response and hands it to the waiting call
+func (r *clientReceiver) Receive(b []byte) error {
+	resp := new(response)
+
+	r.dec.Write(b)
+	err := r.dec.Decode(resp)
+	r.dec.Reset()
+	if err != nil {
+		return err
+	}
+
+	c := r.client
+	c.lock.Lock()
+	ch, ok := c.pending[resp.ID]
+	delete(c.pending, resp.ID)
+	c.lock.Unlock()
+
+	// The call was cancelled
+	if !ok {
+		return nil
+	}
+
+	ch <- resp
+	return nil
+}
+
+// ResetStream resets the decoder when the Server replaced its encoder
+func (r *clientReceiver) ResetStream() {
+	mux.ResetStream(r.dec)
+}
+
+// Close fails all pending calls
+func (r *clientReceiver) Close() error {
+	c := r.client
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.shutdown = true
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package rpc
+
+import (
+	"io"
+	netrpc "net/rpc"
+	"sync"
+
+	"github.com/doubledutch/mux"
+)
+
+// codecBacklog is the number of received frames a codec buffers before
+// blocking the Conn's Recv, until the codec is closed
+const codecBacklog = 16
+
+// codec carries net/rpc messages on a frame type. Each message is a frame
+// holding the header and body encoded with the Conn's Pool.
+type codec struct {
+	conn mux.Conn
+	t    uint8
+
+	frames chan []byte
+	dec    mux.BufferDecoder
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newCodec(conn mux.Conn, t uint8) *codec {
+	c := &codec{
+		conn:   conn,
+		t:      t,
+		frames: make(chan []byte, codecBacklog),
+		done:   make(chan struct{}),
+	}
+	conn.Receive(t, codecReceiver{c})
+	return c
+}
+
+// codecReceiver puts frames on the codec's frames, dropping them once the
+// codec is closed so they can't block Recv
+type codecReceiver struct {
+	c *codec
+}
+
+func (r codecReceiver) Receive(b []byte) error {
+	select {
+	case r.c.frames <- b:
+	case <-r.c.done:
+	}
+	return nil
+}
+
+func (r codecReceiver) Close() error {
+	close(r.c.frames)
+	return nil
+}
+
+// write sends header and body in one frame
+func (c *codec) write(header interface{}, body interface{}) error {
+	enc := c.conn.Pool().NewBufferEncoder()
+	if err := enc.Encode(header); err != nil {
+		return err
+	}
+	if err := enc.Encode(body); err != nil {
+		return err
+	}
+	return c.conn.SendRaw(c.t, enc.Bytes())
+}
+
+// readHeader waits for the next frame and decodes its header
+func (c *codec) readHeader(header interface{}) error {
+	select {
+	case b, ok := <-c.frames:
+		if !ok {
+			return io.EOF
+		}
+		c.dec = c.conn.Pool().NewBufferDecoder()
+		c.dec.Write(b)
+		return c.dec.Decode(header)
+	case <-c.done:
+		return io.EOF
+	}
+}
+
+// readBody decodes the body of the frame read by readHeader, a nil body
+// discards it
+func (c *codec) readBody(body interface{}) error {
+	dec := c.dec
+	c.dec = nil
+	if body == nil || dec == nil {
+		return nil
+	}
+	return dec.Decode(body)
+}
+
+// Close stops reading frames and unregisters the frame type, the Conn is
+// left open
+func (c *codec) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Unregister(c.t)
+	})
+	return nil
+}
+
+// clientCodec is a net/rpc ClientCodec
+type clientCodec struct {
+	*codec
+}
+
+// NewClientCodec creates a net/rpc ClientCodec sending requests and
+// receiving responses on frame type t of conn
+func NewClientCodec(conn mux.Conn, t uint8) netrpc.ClientCodec {
+	return clientCodec{newCodec(conn, t)}
+}
+
+func (c clientCodec) WriteRequest(r *netrpc.Request, body interface{}) error {
+	return c.write(r, body)
+}
+
+func (c clientCodec) ReadResponseHeader(r *netrpc.Response) error {
+	return c.readHeader(r)
+}
+
+func (c clientCodec) ReadResponseBody(body interface{}) error {
+	return c.readBody(body)
+}
+
+// serverCodec is a net/rpc ServerCodec
+type serverCodec struct {
+	*codec
+}
+
+// NewServerCodec creates a net/rpc ServerCodec receiving requests and
+// sending responses on frame type t of conn
+func NewServerCodec(conn mux.Conn, t uint8) netrpc.ServerCodec {
+	return serverCodec{newCodec(conn, t)}
+}
+
+func (c serverCodec) ReadRequestHeader(r *netrpc.Request) error {
+	return c.readHeader(r)
+}
+
+func (c serverCodec) ReadRequestBody(body interface{}) error {
+	return c.readBody(body)
+}
+
+func (c serverCodec) WriteResponse(r *netrpc.Response, body interface{}) error {
+	return c.write(r, body)
+}
@@ -0,0 +1,57 @@
+// Package rpc provides request/response calls on a frame type of a mux.Conn.
+//
+// A Client sends requests and receives responses on the same frame type a
+// Server receives requests and sends responses on, so a Conn can be a Client
+// and a Server at the same time as long as each uses its own frame type.
+package rpc
+
+import (
+	"errors"
+
+	"github.com/doubledutch/mux"
+)
+
+var (
+	// ErrShutdown is returned by calls that can't complete because the Conn
+	// was shutdown
+	ErrShutdown = errors.New("rpc: connection shutdown")
+)
+
+// ServerError is an error returned by a handler on the Server
+type ServerError string
+
+func (e ServerError) Error() string {
+	return string(e)
+}
+
+// request is sent by a Client to call Method, or to cancel the call with ID
+type request struct {
+	ID     uint64
+	Method string
+	Args   []byte
+	Cancel bool
+}
+
+// response is sent by a Server once the call with ID returns
+type response struct {
+	ID    uint64
+	Error string
+	Reply []byte
+}
+
+// encode encodes v using its own encoder so each body carries everything
+// needed to decode it, regardless of the order bodies are decoded in
+func encode(pool mux.Pool, v interface{}) ([]byte, error) {
+	enc := pool.NewBufferEncoder()
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return enc.Bytes(), nil
+}
+
+// decode decodes a body created by encode into v
+func decode(pool mux.Pool, b []byte, v interface{}) error {
+	dec := pool.NewBufferDecoder()
+	dec.Write(b)
+	return dec.Decode(v)
+}
@@ -0,0 +1,168 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/doubledutch/mux"
+)
+
+var (
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// handler is a registered method
+type handler struct {
+	fn    reflect.Value
+	args  reflect.Type
+	reply reflect.Type
+}
+
+// Server serves calls from a Client over a mux.Conn
+type Server struct {
+	conn mux.Conn
+	t    uint8
+
+	// ctx is cancelled when the Conn shuts down
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lock     sync.Mutex
+	handlers map[string]*handler
+	inflight map[uint64]context.CancelFunc
+}
+
+// NewServer creates a Server and registers it to receive requests on t
+func NewServer(conn mux.Conn, t uint8) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &Server{
+		conn:     conn,
+		t:        t,
+		ctx:      ctx,
+		cancel:   cancel,
+		handlers: make(map[string]*handler),
+		inflight: make(map[uint64]context.CancelFunc),
+	}
+
+	conn.Receive(t, &serverReceiver{
+		server: s,
+		dec:    conn.Pool().NewBufferDecoder(),
+	})
+
+	return s
+}
+
+// Handle registers fn to serve method. fn must have the signature
+//
+//	func(ctx context.Context, args *A, reply *R) error
+//
+// ctx is cancelled when the Client cancels the call or the Conn shuts down.
+func (s *Server) Handle(method string, fn interface{}) {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+
+	if t.Kind() != reflect.Func || t.NumIn() != 3 || t.NumOut() != 1 ||
+		t.In(0) != typeOfContext ||
+		t.In(1).Kind() != reflect.Ptr || t.In(2).Kind() != reflect.Ptr ||
+		t.Out(0) != typeOfError {
+		panic("Handle requires a func(context.Context, *A, *R) error")
+	}
+
+	s.lock.Lock()
+	s.handlers[method] = &handler{
+		fn:    v,
+		args:  t.In(1).Elem(),
+		reply: t.In(2).Elem(),
+	}
+	s.lock.Unlock()
+}
+
+// serve calls the handler for req and sends the response
+func (s *Server) serve(ctx context.Context, h *handler, req *request) {
+	defer s.done(req.ID)
+
+	resp := response{ID: req.ID}
+
+	args := reflect.New(h.args)
+	reply := reflect.New(h.reply)
+	err := decode(s.conn.Pool(), req.Args, args.Interface())
+	if err == nil {
+		out := h.fn.Call([]reflect.Value{reflect.ValueOf(ctx), args, reply})
+		if e := out[0].Interface(); e != nil {
+			err = e.(error)
+		}
+	}
+	if err == nil {
+		resp.Reply, err = encode(s.conn.Pool(), reply.Interface())
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+
+	s.conn.Send(s.t, resp)
+}
+
+// done stops tracking the call with id
+func (s *Server) done(id uint64) {
+	s.lock.Lock()
+	if cancel, ok := s.inflight[id]; ok {
+		cancel()
+		delete(s.inflight, id)
+	}
+	s.lock.Unlock()
+}
+
+// serverReceiver receives requests for a Server
+type serverReceiver struct {
+	server *Server
+	dec    mux.BufferDecoder
+}
+
+// Receive decodes a request and serves it in its own goroutine
+func (r *serverReceiver) Receive(b []byte) error {
+	req := new(request)
+
+	r.dec.Write(b)
+	err := r.dec.Decode(req)
+	r.dec.Reset()
+	if err != nil {
+		return err
+	}
+
+	s := r.server
+	if req.Cancel {
+		s.done(req.ID)
+		return nil
+	}
+
+	s.lock.Lock()
+	h, ok := s.handlers[req.Method]
+	if !ok {
+		s.lock.Unlock()
+		return s.conn.Send(s.t, response{
+			ID:    req.ID,
+			Error: fmt.Sprintf("rpc: can't find method %s", req.Method),
+		})
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.inflight[req.ID] = cancel
+	s.lock.Unlock()
+
+	go s.serve(ctx, h, req)
+	return nil
+}
+
+// ResetStream resets the decoder when the Client replaced its encoder
+func (r *serverReceiver) ResetStream() {
+	mux.ResetStream(r.dec)
+}
+
+// Close cancels all calls in flight
+func (r *serverReceiver) Close() error {
+	r.server.cancel()
+	return nil
+}
@@ -0,0 +1,271 @@
+package mux
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// fingerprintLen is the length of a declaration's fingerprint
+	fingerprintLen = 8
+	// schemaEntryLen is the length of a frame type and its fingerprint in a
+	// schema frame
+	schemaEntryLen = 1 + fingerprintLen
+)
+
+var (
+	// ErrTypeDeclared is returned when declaring a frame type twice
+	ErrTypeDeclared = errors.New("Type already declared")
+	// ErrReservedType is returned when declaring a reserved frame type
+	ErrReservedType = errors.New("Reserved type")
+	// ErrWrongType is returned by Send when a value isn't of the type
+	// declared for its frame type
+	ErrWrongType = errors.New("Wrong type")
+	// ErrSchemaDrift is returned by Send for a frame type the peer declared
+	// differently
+	ErrSchemaDrift = errors.New("Schema drift")
+)
+
+// ElemTyper is implemented by Receivers that deliver values of one type, so
+// Receive can check it against the Schema
+type ElemTyper interface {
+	ElemType() reflect.Type
+}
+
+// declaration is a frame type declared in a Schema
+type declaration struct {
+	name        string
+	typ         reflect.Type
+	fingerprint [fingerprintLen]byte
+}
+
+// Schema binds frame types to a name and the Go type of their values.
+// Declarations must be made before the Schema is used by a Conn.
+type Schema struct {
+	lock  sync.RWMutex
+	types map[uint8]declaration
+}
+
+// NewSchema creates an empty Schema
+func NewSchema() *Schema {
+	return &Schema{
+		types: make(map[uint8]declaration),
+	}
+}
+
+// Declare declares that frame type t named name carries values of type T
+func Declare[T any](s *Schema, t uint8, name string) error {
+	return s.DeclareType(t, name, reflect.TypeOf((*T)(nil)).Elem())
+}
+
+// DeclareType declares that frame type t named name carries values of typ
+func (s *Schema) DeclareType(t uint8, name string, typ reflect.Type) error {
+	if reserved(t) {
+		return ErrReservedType
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.types[t]; ok {
+		return ErrTypeDeclared
+	}
+
+	d := declaration{
+		name: name,
+		typ:  typ,
+	}
+	sum := sha256.Sum256([]byte(name + " " + describe(typ, nil)))
+	copy(d.fingerprint[:], sum[:])
+	s.types[t] = d
+	return nil
+}
+
+// Type returns the name and type declared for t
+func (s *Schema) Type(t uint8) (string, reflect.Type, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	d, ok := s.types[t]
+	return d.name, d.typ, ok
+}
+
+// Fingerprint returns a hash of every declaration, peers with the same
+// fingerprint agree on all frame types
+func (s *Schema) Fingerprint() []byte {
+	h := sha256.New()
+	h.Write(s.entries())
+	return h.Sum(nil)
+}
+
+// entries returns each frame type followed by its fingerprint, in order
+func (s *Schema) entries() []byte {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	types := make([]int, 0, len(s.types))
+	for t := range s.types {
+		types = append(types, int(t))
+	}
+	sort.Ints(types)
+
+	b := make([]byte, 0, len(types)*schemaEntryLen)
+	for _, t := range types {
+		fp := s.types[uint8(t)].fingerprint
+		b = append(b, uint8(t))
+		b = append(b, fp[:]...)
+	}
+	return b
+}
+
+// checkValue returns ErrWrongType unless v is of the type declared for t
+func (s *Schema) checkValue(t uint8, v interface{}) error {
+	_, typ, ok := s.Type(t)
+	if !ok || assignable(reflect.TypeOf(v), typ) {
+		return nil
+	}
+	return fmt.Errorf("%w: %T sent on type %d declared %s", ErrWrongType, v, t, typ)
+}
+
+// checkReceiver returns ErrWrongType unless r delivers the type declared for
+// t, or doesn't say what it delivers
+func (s *Schema) checkReceiver(t uint8, r Receiver) error {
+	_, typ, ok := s.Type(t)
+	et, isTyper := r.(ElemTyper)
+	if !ok || !isTyper || assignable(et.ElemType(), typ) {
+		return nil
+	}
+	return fmt.Errorf("%w: receiver of %s for type %d declared %s", ErrWrongType, et.ElemType(), t, typ)
+}
+
+// assignable returns whether values of from can be encoded or decoded as to,
+// a pointer is as good as the value it points to
+func assignable(from, to reflect.Type) bool {
+	if from == nil {
+		return to.Kind() == reflect.Interface
+	}
+	if to.Kind() == reflect.Interface {
+		return from.Implements(to)
+	}
+	for from.Kind() == reflect.Ptr && from != to {
+		from = from.Elem()
+	}
+	for to.Kind() == reflect.Ptr && to != from {
+		to = to.Elem()
+	}
+	return from == to
+}
+
+// describe describes the structure of typ, so peers built from different
+// code agree as long as the structure is the same
+func describe(typ reflect.Type, seen map[reflect.Type]bool) string {
+	switch typ.Kind() {
+	case reflect.Ptr:
+		return describe(typ.Elem(), seen)
+	case reflect.Slice:
+		return "[]" + describe(typ.Elem(), seen)
+	case reflect.Array:
+		return "[" + strconv.Itoa(typ.Len()) + "]" + describe(typ.Elem(), seen)
+	case reflect.Map:
+		return "map[" + describe(typ.Key(), seen) + "]" + describe(typ.Elem(), seen)
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.UnsafePointer:
+		return typ.Kind().String()
+	case reflect.Struct:
+		// Recursive types are described by name the second time
+		if seen[typ] {
+			return typ.Name()
+		}
+		if seen == nil {
+			seen = make(map[reflect.Type]bool)
+		}
+		seen[typ] = true
+		defer delete(seen, typ)
+
+		var b strings.Builder
+		b.WriteString("struct{")
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			if f.PkgPath != "" && !f.Anonymous {
+				continue
+			}
+			fmt.Fprintf(&b, "%s %s %q;", f.Name, describe(f.Type, seen), f.Tag)
+		}
+		b.WriteString("}")
+		return b.String()
+	default:
+		return typ.Kind().String()
+	}
+}
+
+// schemaFrame creates the frame telling the peer our fingerprints
+func (c *conn) schemaFrame() Frame {
+	return Frame{
+		Type: SchemaType,
+		Data: c.schema.entries(),
+	}
+}
+
+// recvSchema compares the peer's fingerprints with ours, frame types
+// declared on both sides with different fingerprints have drifted
+func (c *conn) recvSchema(b []byte) {
+	if len(b)%schemaEntryLen != 0 {
+		c.lgr.Warnf("dropping malformed schema frame\n")
+		return
+	}
+	if c.schema == nil {
+		return
+	}
+
+	c.schema.lock.RLock()
+	var drift []uint8
+	for ; len(b) > 0; b = b[schemaEntryLen:] {
+		t := b[0]
+		d, ok := c.schema.types[t]
+		if ok && string(d.fingerprint[:]) != string(b[1:schemaEntryLen]) {
+			drift = append(drift, t)
+		}
+	}
+	c.schema.lock.RUnlock()
+
+	for _, t := range drift {
+		c.lgr.Warnf("Peer %s declared type %d differently", c.conn.RemoteAddr(), t)
+	}
+	c.driftLock.Lock()
+	c.drift = drift
+	c.driftLock.Unlock()
+}
+
+// SchemaDrift returns the frame types the peer declared with a different
+// name or structure, once its schema arrived
+func (c *conn) SchemaDrift() []uint8 {
+	c.driftLock.Lock()
+	defer c.driftLock.Unlock()
+	return append([]uint8(nil), c.drift...)
+}
+
+// checkDrift returns ErrSchemaDrift if the peer declared t differently
+func (c *conn) checkDrift(t uint8) error {
+	c.driftLock.Lock()
+	defer c.driftLock.Unlock()
+	for _, drifted := range c.drift {
+		if drifted == t {
+			return ErrSchemaDrift
+		}
+	}
+	return nil
+}
+
+// checkSend returns an error if v can't be sent on t
+func (c *conn) checkSend(t uint8, v interface{}) error {
+	if c.schema == nil {
+		return nil
+	}
+	if err := c.checkDrift(t); err != nil {
+		return err
+	}
+	return c.schema.checkValue(t, v)
+}
@@ -0,0 +1,228 @@
+package secure
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+)
+
+// Records
+//
+// After the handshake everything is sent as records:
+//
+//	[length uint32][AES-256-GCM sealed [type uint8][data]]
+//
+// The length is authenticated as additional data. Nonces are a per-direction
+// record counter that's never sent, so a replayed, reordered or dropped
+// record fails to open. A rekey record tells the peer the sender switched to
+// the next key, derived from the current one with HKDF, and restarted its
+// counter.
+
+const (
+	recordData     uint8 = 0
+	recordRekey    uint8 = 1
+	recordFinished uint8 = 2
+
+	recordHeaderLen = 4
+	// maxRecordData is the most data sent in a record
+	maxRecordData = 16 * 1024
+	// maxRecordLen is the longest sealed record
+	maxRecordLen = 1 + maxRecordData + 16
+
+	readChunk = 32 * 1024
+)
+
+var (
+	// ErrTampered is returned when a record fails authentication, a mux.Conn
+	// reading it shuts down with this error
+	ErrTampered = errors.New("secure: record tampered with")
+)
+
+// halfConn is the state of one direction
+type halfConn struct {
+	key  []byte
+	aead cipher.AEAD
+	seq  uint64
+	// bytes is how much data was sent with key
+	bytes int64
+}
+
+func (h *halfConn) setKey(key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	h.key = key
+	h.aead = aead
+	h.seq = 0
+	h.bytes = 0
+	return nil
+}
+
+// rekey switches to the next key
+func (h *halfConn) rekey() error {
+	return h.setKey(hkdfExpand(h.key, infoRekey))
+}
+
+// nonce returns the nonce of the next record
+func (h *halfConn) nonce() []byte {
+	nonce := make([]byte, h.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], h.seq)
+	h.seq++
+	return nonce
+}
+
+// Conn is a net.Conn encrypting and authenticating everything written to it
+type Conn struct {
+	net.Conn
+
+	peerKey    *ecdh.PublicKey
+	rekeyBytes int64
+
+	writeLock sync.Mutex
+	out       halfConn
+	writeErr  error
+
+	readLock sync.Mutex
+	in       halfConn
+	// raw holds bytes read that don't make a whole record yet, so a read
+	// that times out can be retried
+	raw     []byte
+	plain   []byte
+	readErr error
+}
+
+// PeerKey returns the peer's static key
+func (c *Conn) PeerKey() *ecdh.PublicKey {
+	return c.peerKey
+}
+
+// Write encrypts p into records, rotating the key every RekeyBytes
+func (c *Conn) Write(p []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if c.writeErr != nil {
+		return 0, c.writeErr
+	}
+
+	n := 0
+	for len(p) > 0 {
+		data := p[:min(len(p), maxRecordData)]
+		if err := c.writeRecord(recordData, data); err != nil {
+			// A partial record can't be recovered from
+			c.writeErr = err
+			return n, err
+		}
+		n += len(data)
+		p = p[len(data):]
+
+		if c.out.bytes >= c.rekeyBytes {
+			if err := c.writeRecord(recordRekey, nil); err != nil {
+				c.writeErr = err
+				return n, err
+			}
+			if err := c.out.rekey(); err != nil {
+				c.writeErr = err
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// writeRecord seals and writes a record
+func (c *Conn) writeRecord(t uint8, data []byte) error {
+	b := make([]byte, recordHeaderLen, recordHeaderLen+1+len(data)+c.out.aead.Overhead())
+	binary.BigEndian.PutUint32(b, uint32(1+len(data)+c.out.aead.Overhead()))
+
+	plain := make([]byte, 0, 1+len(data))
+	plain = append(plain, t)
+	plain = append(plain, data...)
+	b = c.out.aead.Seal(b, c.out.nonce(), plain, b[:recordHeaderLen])
+	c.out.bytes += int64(len(data))
+
+	_, err := c.Conn.Write(b)
+	return err
+}
+
+// Read decrypts records into p. Once a record fails authentication every
+// Read returns ErrTampered.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	for len(c.plain) == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+
+		t, data, err := c.readRecord()
+		if err != nil {
+			return 0, err
+		}
+		switch t {
+		case recordData:
+			c.plain = data
+		case recordRekey:
+			if err := c.in.rekey(); err != nil {
+				return 0, err
+			}
+		default:
+			c.readErr = ErrTampered
+		}
+	}
+
+	n := copy(p, c.plain)
+	c.plain = c.plain[n:]
+	return n, nil
+}
+
+// readRecord reads and opens the next record
+func (c *Conn) readRecord() (uint8, []byte, error) {
+	if err := c.fill(recordHeaderLen); err != nil {
+		return 0, nil, err
+	}
+	n := int(binary.BigEndian.Uint32(c.raw))
+	if n < 1+c.in.aead.Overhead() || n > maxRecordLen {
+		c.readErr = ErrTampered
+		return 0, nil, c.readErr
+	}
+	if err := c.fill(recordHeaderLen + n); err != nil {
+		return 0, nil, err
+	}
+
+	record := c.raw[:recordHeaderLen+n]
+	plain, err := c.in.aead.Open(nil, c.in.nonce(), record[recordHeaderLen:], record[:recordHeaderLen])
+	c.raw = append(c.raw[:0], c.raw[len(record):]...)
+	if err != nil {
+		c.readErr = ErrTampered
+		return 0, nil, c.readErr
+	}
+	return plain[0], plain[1:], nil
+}
+
+// fill reads until raw holds at least n bytes
+func (c *Conn) fill(n int) error {
+	for len(c.raw) < n {
+		if cap(c.raw)-len(c.raw) < readChunk {
+			raw := make([]byte, len(c.raw), len(c.raw)+max(readChunk, n))
+			copy(raw, c.raw)
+			c.raw = raw
+		}
+		m, err := c.Conn.Read(c.raw[len(c.raw):cap(c.raw)])
+		c.raw = c.raw[:len(c.raw)+m]
+		if err != nil && len(c.raw) < n {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// The handshake
+//
+// Each side sends a hello holding its static and ephemeral X25519 public
+// keys:
+//
+//	["MUXS"][version uint8][static 32 bytes][ephemeral 32 bytes]
+//
+// The peer's static key must be one of the pinned Config.PeerKeys. Both
+// sides then combine three key exchanges, ephemeral-ephemeral and each
+// static key with the other side's ephemeral key, so only holders of the
+// pinned private keys can derive the session keys. The shared secret is
+// expanded with HKDF-SHA256, salted with a hash of both hellos, into an
+// AES-256-GCM key for each direction. Finally each side sends an encrypted
+// finished record to prove it derived the same keys.
+
+const (
+	// version of the secure protocol
+	version uint8 = 1
+
+	keyLen   = 32
+	helloLen = 4 + 1 + 2*keyLen
+
+	// DefaultHandshakeTimeout is used when the Config doesn't specify
+	// HandshakeTimeout
+	DefaultHandshakeTimeout = 10 * time.Second
+	// DefaultRekeyBytes is used when the Config doesn't specify RekeyBytes
+	DefaultRekeyBytes = 1 << 30
+)
+
+var (
+	magic = []byte("MUXS")
+
+	infoClient = []byte("mux secure client to server")
+	infoServer = []byte("mux secure server to client")
+	infoRekey  = []byte("mux secure rekey")
+
+	// ErrHandshake is returned when the peer fails the handshake
+	ErrHandshake = errors.New("secure: handshake failed")
+	// ErrUnknownPeer is returned when the peer's static key isn't pinned
+	ErrUnknownPeer = errors.New("secure: unknown peer key")
+	// ErrInvalidPrivateKey defines an error for an invalid Config PrivateKey
+	ErrInvalidPrivateKey = errors.New("secure: invalid Config PrivateKey")
+	// ErrInvalidPeerKeys defines an error for an invalid Config PeerKeys
+	ErrInvalidPeerKeys = errors.New("secure: invalid Config PeerKeys")
+	// ErrInvalidRekeyBytes defines an error for an invalid Config RekeyBytes
+	ErrInvalidRekeyBytes = errors.New("secure: invalid Config RekeyBytes")
+)
+
+// Config configures a secure session
+type Config struct {
+	// PrivateKey is our static X25519 key
+	PrivateKey *ecdh.PrivateKey
+
+	// PeerKeys are the static X25519 public keys peers may use
+	PeerKeys []*ecdh.PublicKey
+
+	// RekeyBytes is how many bytes are sent before the sending key is
+	// rotated. Zero uses DefaultRekeyBytes.
+	RekeyBytes int64
+
+	// HandshakeTimeout bounds the handshake, zero uses
+	// DefaultHandshakeTimeout
+	HandshakeTimeout time.Duration
+}
+
+// Verify verifies the Config
+func (c *Config) Verify() error {
+	if c.PrivateKey == nil || c.PrivateKey.Curve() != ecdh.X25519() {
+		return ErrInvalidPrivateKey
+	}
+
+	if len(c.PeerKeys) == 0 {
+		return ErrInvalidPeerKeys
+	}
+	for _, k := range c.PeerKeys {
+		if k == nil || k.Curve() != ecdh.X25519() {
+			return ErrInvalidPeerKeys
+		}
+	}
+
+	if c.RekeyBytes < 0 {
+		return ErrInvalidRekeyBytes
+	}
+
+	return nil
+}
+
+// GenerateKey generates a static X25519 key
+func GenerateKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// Client starts a secure session on conn as the side that connected
+func Client(conn net.Conn, config *Config) (*Conn, error) {
+	return handshake(conn, config, true)
+}
+
+// Server starts a secure session on conn as the side that accepted
+func Server(conn net.Conn, config *Config) (*Conn, error) {
+	return handshake(conn, config, false)
+}
+
+func handshake(conn net.Conn, config *Config, isClient bool) (*Conn, error) {
+	if err := config.Verify(); err != nil {
+		return nil, err
+	}
+
+	timeout := config.HandshakeTimeout
+	if timeout == 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	ephemeral, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	hello := make([]byte, 0, helloLen)
+	hello = append(hello, magic...)
+	hello = append(hello, version)
+	hello = append(hello, config.PrivateKey.PublicKey().Bytes()...)
+	hello = append(hello, ephemeral.PublicKey().Bytes()...)
+
+	// Hellos are small enough for both sides to write before reading
+	if _, err := conn.Write(hello); err != nil {
+		return nil, err
+	}
+	peerHello := make([]byte, helloLen)
+	if _, err := io.ReadFull(conn, peerHello); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(peerHello[:len(magic)], magic) || peerHello[len(magic)] != version {
+		return nil, ErrHandshake
+	}
+
+	peerStatic, err := ecdh.X25519().NewPublicKey(peerHello[5 : 5+keyLen])
+	if err != nil {
+		return nil, ErrHandshake
+	}
+	peerEphemeral, err := ecdh.X25519().NewPublicKey(peerHello[5+keyLen:])
+	if err != nil {
+		return nil, ErrHandshake
+	}
+	if !pinned(config.PeerKeys, peerStatic) {
+		return nil, ErrUnknownPeer
+	}
+
+	// Mix in the key exchanges and hellos in the same order on both sides
+	clientHello, serverHello := hello, peerHello
+	if !isClient {
+		clientHello, serverHello = peerHello, hello
+	}
+	ee, err := ephemeral.ECDH(peerEphemeral)
+	if err != nil {
+		return nil, ErrHandshake
+	}
+	se, err := config.PrivateKey.ECDH(peerEphemeral)
+	if err != nil {
+		return nil, ErrHandshake
+	}
+	es, err := ephemeral.ECDH(peerStatic)
+	if err != nil {
+		return nil, ErrHandshake
+	}
+	clientStatic, serverStatic := se, es
+	if !isClient {
+		clientStatic, serverStatic = es, se
+	}
+
+	transcript := sha256.New()
+	transcript.Write(clientHello)
+	transcript.Write(serverHello)
+	prk := hkdfExtract(transcript.Sum(nil), ee, clientStatic, serverStatic)
+
+	sendInfo, recvInfo := infoClient, infoServer
+	if !isClient {
+		sendInfo, recvInfo = infoServer, infoClient
+	}
+
+	c := &Conn{
+		Conn:       conn,
+		peerKey:    peerStatic,
+		rekeyBytes: config.RekeyBytes,
+	}
+	if c.rekeyBytes == 0 {
+		c.rekeyBytes = DefaultRekeyBytes
+	}
+	if err := c.out.setKey(hkdfExpand(prk, sendInfo)); err != nil {
+		return nil, err
+	}
+	if err := c.in.setKey(hkdfExpand(prk, recvInfo)); err != nil {
+		return nil, err
+	}
+
+	if err := c.writeRecord(recordFinished, nil); err != nil {
+		return nil, err
+	}
+	t, _, err := c.readRecord()
+	if err == ErrTampered || (err == nil && t != recordFinished) {
+		return nil, ErrHandshake
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func pinned(keys []*ecdh.PublicKey, key *ecdh.PublicKey) bool {
+	for _, k := range keys {
+		if k.Equal(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// hkdfExtract is HKDF-Extract with SHA-256 over the concatenation of ikm
+func hkdfExtract(salt []byte, ikm ...[]byte) []byte {
+	h := hmac.New(sha256.New, salt)
+	for _, b := range ikm {
+		h.Write(b)
+	}
+	return h.Sum(nil)
+}
+
+// hkdfExpand is HKDF-Expand with SHA-256 for a single block key
+func hkdfExpand(prk, info []byte) []byte {
+	h := hmac.New(sha256.New, prk)
+	h.Write(info)
+	h.Write([]byte{1})
+	return h.Sum(nil)
+}
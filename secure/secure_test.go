@@ -0,0 +1,252 @@
+package secure
+
+import (
+	"crypto/ecdh"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/mux"
+	"github.com/doubledutch/mux/gob"
+)
+
+// tamperConn is a net.Conn that can corrupt or replay what's written to it
+type tamperConn struct {
+	net.Conn
+
+	lock   sync.Mutex
+	tamper bool
+	last   []byte
+}
+
+func (c *tamperConn) Write(p []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.last = append([]byte(nil), p...)
+	if c.tamper {
+		b := append([]byte(nil), p...)
+		b[len(b)-1] ^= 1
+		return c.Conn.Write(b)
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *tamperConn) setTamper() {
+	c.lock.Lock()
+	c.tamper = true
+	c.lock.Unlock()
+}
+
+func (c *tamperConn) replay() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, err := c.Conn.Write(c.last)
+	return err
+}
+
+type keys struct {
+	client, server *ecdh.PrivateKey
+}
+
+func newKeys(t *testing.T) keys {
+	client, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys{client: client, server: server}
+}
+
+// handshakePair runs a handshake over TCP, the client writing through a
+// tamperConn
+func handshakePair(t *testing.T, clientConfig, serverConfig *Config) (client, server *Conn, tc *tamperConn, clientErr, serverErr error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	accepted := make(chan result, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			accepted <- result{err: err}
+			return
+		}
+		sc, err := Server(conn, serverConfig)
+		if err != nil {
+			conn.Close()
+		}
+		accepted <- result{sc, err}
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc = &tamperConn{Conn: conn}
+	client, clientErr = Client(tc, clientConfig)
+	if clientErr != nil {
+		conn.Close()
+	}
+	r := <-accepted
+	if r.err != nil && client != nil {
+		client.Close()
+	}
+	return client, r.conn, tc, clientErr, r.err
+}
+
+// muxPair returns mux.Conns over a secure client and server
+func muxPair(t *testing.T, clientConfig, serverConfig *Config) (mux.Conn, mux.Conn, *tamperConn) {
+	client, server, tc, clientErr, serverErr := handshakePair(t, clientConfig, serverConfig)
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+	if serverErr != nil {
+		t.Fatal(serverErr)
+	}
+
+	config := &mux.Config{
+		Timeout: time.Second,
+		Lager:   mux.DefaultConfig().Lager,
+	}
+	clientConn, err := gob.NewConn(client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConn, err := gob.NewConn(server, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go clientConn.Recv()
+	go serverConn.Recv()
+	return clientConn, serverConn, tc
+}
+
+func configs(k keys) (*Config, *Config) {
+	return &Config{
+		PrivateKey: k.client,
+		PeerKeys:   []*ecdh.PublicKey{k.server.PublicKey()},
+	}, &Config{
+		PrivateKey: k.server,
+		PeerKeys:   []*ecdh.PublicKey{k.client.PublicKey()},
+	}
+}
+
+func TestSecureConn(t *testing.T) {
+	clientConfig, serverConfig := configs(newKeys(t))
+	// Rotate keys every few frames
+	clientConfig.RekeyBytes = 100
+
+	client, server, _ := muxPair(t, clientConfig, serverConfig)
+	defer client.Shutdown()
+	defer server.Shutdown()
+
+	n := 50
+	logCh := make(chan string, n)
+	server.Receive(mux.LogType, new(gob.Pool).NewReceiver(logCh))
+
+	long := strings.Repeat("hello world", 10000)
+	for i := 0; i < n; i++ {
+		text := "hello world"
+		if i%10 == 0 {
+			text = long
+		}
+		if err := client.Send(mux.LogType, text); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case actual := <-logCh:
+			if actual != text {
+				t.Fatalf("frame %d: got %d bytes, expected %d", i, len(actual), len(text))
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("frame %d not received", i)
+		}
+	}
+}
+
+func TestPinning(t *testing.T) {
+	k := newKeys(t)
+	other := newKeys(t)
+
+	// The server doesn't know the client's key
+	clientConfig, serverConfig := configs(k)
+	serverConfig.PeerKeys = []*ecdh.PublicKey{other.client.PublicKey()}
+	_, _, _, clientErr, serverErr := handshakePair(t, clientConfig, serverConfig)
+	if serverErr != ErrUnknownPeer {
+		t.Fatalf("expected %s, got %v", ErrUnknownPeer, serverErr)
+	}
+	if clientErr == nil {
+		t.Fatal("expected client handshake to fail")
+	}
+
+	// The client expects another server
+	clientConfig, serverConfig = configs(k)
+	clientConfig.PeerKeys = []*ecdh.PublicKey{other.server.PublicKey()}
+	_, _, _, clientErr, serverErr = handshakePair(t, clientConfig, serverConfig)
+	if clientErr != ErrUnknownPeer {
+		t.Fatalf("expected %s, got %v", ErrUnknownPeer, clientErr)
+	}
+	if serverErr == nil {
+		t.Fatal("expected server handshake to fail")
+	}
+
+	if _, err := Client(nil, &Config{PrivateKey: k.client}); err != ErrInvalidPeerKeys {
+		t.Fatalf("expected %s, got %v", ErrInvalidPeerKeys, err)
+	}
+	if _, err := Client(nil, &Config{PeerKeys: clientConfig.PeerKeys}); err != ErrInvalidPrivateKey {
+		t.Fatalf("expected %s, got %v", ErrInvalidPrivateKey, err)
+	}
+}
+
+func TestTampered(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		attack func(*tamperConn, mux.Conn) error
+	}{
+		{"modified", func(c *tamperConn, client mux.Conn) error {
+			c.setTamper()
+			return client.Send(mux.LogType, "hello world")
+		}},
+		{"replayed", func(c *tamperConn, client mux.Conn) error {
+			return c.replay()
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clientConfig, serverConfig := configs(newKeys(t))
+			client, server, conn := muxPair(t, clientConfig, serverConfig)
+			defer client.Shutdown()
+			defer server.Shutdown()
+
+			logCh := make(chan string, 2)
+			server.Receive(mux.LogType, new(gob.Pool).NewReceiver(logCh))
+			if err := client.Send(mux.LogType, "hello world"); err != nil {
+				t.Fatal(err)
+			}
+			<-logCh
+
+			if err := tc.attack(conn, client); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-server.IsShutdown():
+			case <-time.After(time.Second):
+				t.Fatal("server not shutdown")
+			}
+			if err := server.Err(); err != ErrTampered {
+				t.Fatalf("expected %s, got %v", ErrTampered, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,503 @@
+package mux
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+const (
+	// flagSYN opens a stream
+	flagSYN uint8 = 1 << iota
+	// flagACK acknowledges a SYN
+	flagACK
+	// flagFIN half-closes the sender's side of a stream
+	flagFIN
+	// flagRST aborts a stream
+	flagRST
+	// flagWND grants the peer more send window
+	flagWND
+)
+
+const (
+	// streamHeaderLen is the length of the id and flags preceding stream data
+	streamHeaderLen = 5
+	// maxStreamData is the largest payload carried by a single stream frame
+	maxStreamData = 16 * 1024
+	// acceptorBit marks stream frames sent by the side that accepted the stream
+	acceptorBit uint32 = 1 << 31
+	// acceptBacklog is the number of streams waiting for AcceptStream
+	acceptBacklog = 256
+	// DefaultStreamWindow is the receive window of a stream when the Config
+	// doesn't specify one
+	DefaultStreamWindow uint32 = 256 * 1024
+)
+
+var (
+	// ErrShutdown is returned when using a Conn that has been shutdown
+	ErrShutdown = errors.New("Connection shutdown")
+	// ErrStreamClosed is returned when using a closed Stream
+	ErrStreamClosed = errors.New("Stream closed")
+	// ErrStreamReset is returned when a Stream was reset by either side
+	ErrStreamReset = errors.New("Stream reset")
+	// ErrStreamRefused is returned by OpenStream when the peer refuses a Stream
+	ErrStreamRefused = errors.New("Stream refused")
+	// ErrStreamsExhausted is returned by OpenStream when no stream ids are left
+	ErrStreamsExhausted = errors.New("Stream ids exhausted")
+	// ErrFlowControl is returned when the peer sends more than its window allows
+	ErrFlowControl = errors.New("Stream flow control violated")
+)
+
+// Stream is a logical bidirectional byte stream multiplexed over a Conn
+type Stream interface {
+	io.ReadWriteCloser
+	// ID returns the id of the stream, unique among streams opened by one side
+	ID() uint32
+	// CloseWrite half-closes the stream, the peer reads io.EOF once it has
+	// read everything written before
+	CloseWrite() error
+	// Reset aborts the stream in both directions
+	Reset() error
+}
+
+// streamKey identifies a stream by its id and which side opened it
+type streamKey struct {
+	id    uint32
+	local bool
+}
+
+// streams tracks the logical streams of a conn
+type streams struct {
+	lock   sync.Mutex
+	nextID uint32
+	active map[streamKey]*stream
+	accept chan *stream
+
+	// window is the receive window advertised for each stream
+	window uint32
+}
+
+func newStreams(window uint32) *streams {
+	if window == 0 {
+		window = DefaultStreamWindow
+	}
+
+	return &streams{
+		window: window,
+		nextID: 1,
+		active: make(map[streamKey]*stream),
+		accept: make(chan *stream, acceptBacklog),
+	}
+}
+
+// stream is a Stream on a conn
+type stream struct {
+	id    uint32
+	local bool
+	conn  *conn
+
+	lock sync.Mutex
+	cond *sync.Cond
+	buf  bytes.Buffer
+
+	established bool
+	localFIN    bool
+	remoteFIN   bool
+	closed      bool
+	err         error
+
+	// sendWindow is how many bytes the peer will still accept
+	sendWindow uint32
+	// recvWindow is how many bytes we still accept from the peer
+	recvWindow uint32
+	// consumed counts bytes read since the last window update
+	consumed uint32
+}
+
+func newStream(c *conn, id uint32, local bool) *stream {
+	s := &stream{
+		id:         id,
+		local:      local,
+		conn:       c,
+		recvWindow: c.streams.window,
+	}
+	s.cond = sync.NewCond(&s.lock)
+	return s
+}
+
+func (s *stream) key() streamKey {
+	return streamKey{id: s.id, local: s.local}
+}
+
+// ID returns the id of the stream
+func (s *stream) ID() uint32 {
+	return s.id
+}
+
+// Read reads data sent by the peer
+func (s *stream) Read(b []byte) (int, error) {
+	s.lock.Lock()
+	for {
+		if s.closed {
+			s.lock.Unlock()
+			return 0, ErrStreamClosed
+		}
+		if s.buf.Len() > 0 {
+			break
+		}
+		if s.err != nil {
+			s.lock.Unlock()
+			return 0, s.err
+		}
+		if s.remoteFIN {
+			s.lock.Unlock()
+			return 0, io.EOF
+		}
+		s.cond.Wait()
+	}
+
+	n, _ := s.buf.Read(b)
+
+	// Grant the peer more window once half of it has been consumed
+	var update uint32
+	s.consumed += uint32(n)
+	if s.consumed >= s.conn.streams.window/2 && !s.remoteFIN {
+		update = s.consumed
+		s.recvWindow += update
+		s.consumed = 0
+	}
+	s.lock.Unlock()
+
+	if update > 0 {
+		s.send(flagWND, windowBytes(update))
+	}
+	return n, nil
+}
+
+// Write sends b to the peer, blocking while the peer's window is exhausted
+func (s *stream) Write(b []byte) (int, error) {
+	n := 0
+	for len(b) > 0 {
+		s.lock.Lock()
+		for s.sendWindow == 0 && s.writable() == nil {
+			s.cond.Wait()
+		}
+		if err := s.writable(); err != nil {
+			s.lock.Unlock()
+			return n, err
+		}
+
+		chunk := b
+		if len(chunk) > maxStreamData {
+			chunk = chunk[:maxStreamData]
+		}
+		if uint32(len(chunk)) > s.sendWindow {
+			chunk = chunk[:s.sendWindow]
+		}
+		s.sendWindow -= uint32(len(chunk))
+		s.lock.Unlock()
+
+		if err := s.send(0, chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		b = b[len(chunk):]
+	}
+	return n, nil
+}
+
+// writable reports why the stream can't be written to, the lock must be held
+func (s *stream) writable() error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.closed || s.localFIN {
+		return ErrStreamClosed
+	}
+	return nil
+}
+
+// CloseWrite half-closes the stream
+func (s *stream) CloseWrite() error {
+	s.lock.Lock()
+	if err := s.writable(); err != nil {
+		s.lock.Unlock()
+		return err
+	}
+	s.localFIN = true
+	done := s.remoteFIN
+	s.lock.Unlock()
+
+	if done {
+		s.conn.streams.remove(s)
+	}
+	return s.send(flagFIN, nil)
+}
+
+// Close closes the stream, unread data is discarded
+func (s *stream) Close() error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.closed = true
+	sendFIN := !s.localFIN && s.err == nil
+	s.localFIN = true
+	done := s.remoteFIN || s.err != nil
+	s.buf.Reset()
+	s.cond.Broadcast()
+	s.lock.Unlock()
+
+	if done {
+		s.conn.streams.remove(s)
+	}
+	if sendFIN {
+		return s.send(flagFIN, nil)
+	}
+	return nil
+}
+
+// Reset aborts the stream
+func (s *stream) Reset() error {
+	if !s.fail(ErrStreamReset) {
+		return nil
+	}
+
+	s.conn.streams.remove(s)
+	return s.send(flagRST, nil)
+}
+
+// send sends a stream frame with flags and data, waiting for it to be written
+func (s *stream) send(flags uint8, data []byte) error {
+	f := s.frame(flags, data)
+
+	// Data takes turns with frames from Send, everything else is control
+	if flags == 0 {
+		return s.conn.sendFrameContext(context.Background(), s.conn.streamQueue, f)
+	}
+	return s.conn.sendFrame(f)
+}
+
+// frame creates a stream frame with flags and data
+func (s *stream) frame(flags uint8, data []byte) Frame {
+	id := s.id
+	if !s.local {
+		id |= acceptorBit
+	}
+
+	b := make([]byte, streamHeaderLen+len(data))
+	binary.BigEndian.PutUint32(b, id)
+	b[4] = flags
+	copy(b[streamHeaderLen:], data)
+
+	return Frame{
+		Type: StreamType,
+		Data: b,
+	}
+}
+
+// windowBytes encodes a window size or update for SYN, ACK and WND frames
+func windowBytes(n uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, n)
+	return b
+}
+
+// receive handles a stream frame from the peer. SYN, ACK and WND frames carry
+// a window instead of data. It returns ErrFlowControl if the peer overran
+// its window.
+func (s *stream) receive(flags uint8, data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	defer s.cond.Broadcast()
+
+	if flags&flagRST != 0 {
+		if s.err == nil {
+			s.err = ErrStreamReset
+		}
+		return nil
+	}
+	if flags&(flagSYN|flagACK|flagWND) != 0 {
+		if len(data) != 4 {
+			return ErrFlowControl
+		}
+		s.sendWindow += binary.BigEndian.Uint32(data)
+		if flags&flagACK != 0 {
+			s.established = true
+		}
+		return nil
+	}
+
+	if uint32(len(data)) > s.recvWindow {
+		return ErrFlowControl
+	}
+	if len(data) > 0 && s.closed {
+		// Nobody will read it, stop the peer from waiting on window
+		return ErrStreamClosed
+	}
+	s.recvWindow -= uint32(len(data))
+	if len(data) > 0 && !s.closed && !s.remoteFIN && s.err == nil {
+		s.buf.Write(data)
+	}
+	if flags&flagFIN != 0 {
+		s.remoteFIN = true
+	}
+	return nil
+}
+
+// fail terminates the stream with err, returning false if it already failed
+func (s *stream) fail(err error) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	defer s.cond.Broadcast()
+
+	if s.err != nil {
+		return false
+	}
+	s.err = err
+	return true
+}
+
+// done reports whether the stream no longer needs to receive frames
+func (s *stream) done() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.err != nil || (s.localFIN && s.remoteFIN)
+}
+
+func (ss *streams) remove(s *stream) {
+	ss.lock.Lock()
+	if ss.active[s.key()] == s {
+		delete(ss.active, s.key())
+	}
+	ss.lock.Unlock()
+}
+
+// OpenStream opens a new logical stream to the peer. It waits for the peer to
+// acknowledge the stream, so Recv must be running.
+func (c *conn) OpenStream() (Stream, error) {
+	caps, err := c.waitHello(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if !caps.Has(CapStreams) {
+		return nil, ErrStreamsUnsupported
+	}
+
+	c.streams.lock.Lock()
+	if c.streams.nextID&acceptorBit != 0 {
+		c.streams.lock.Unlock()
+		return nil, ErrStreamsExhausted
+	}
+	s := newStream(c, c.streams.nextID, true)
+	c.streams.nextID++
+	c.streams.active[s.key()] = s
+	c.streams.lock.Unlock()
+
+	if err := s.send(flagSYN, windowBytes(s.recvWindow)); err != nil {
+		c.streams.remove(s)
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for !s.established && s.err == nil {
+		s.cond.Wait()
+	}
+	if s.err == ErrStreamReset && !s.established {
+		return nil, ErrStreamRefused
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s, nil
+}
+
+// AcceptStream waits for and returns the next stream opened by the peer
+func (c *conn) AcceptStream() (Stream, error) {
+	select {
+	case s := <-c.streams.accept:
+		return s, nil
+	case <-c.ShutdownCh:
+		return nil, ErrShutdown
+	}
+}
+
+// recvStream dispatches a stream frame to its stream
+func (c *conn) recvStream(b []byte) {
+	if caps, _ := c.negotiated(); !caps.Has(CapStreams) {
+		c.lgr.Warnf("dropping stream frame, streams are disabled\n")
+		return
+	}
+	if len(b) < streamHeaderLen {
+		c.lgr.Warnf("dropping short stream frame\n")
+		return
+	}
+	id := binary.BigEndian.Uint32(b)
+	flags := b[4]
+	data := b[streamHeaderLen:]
+
+	// Frames from the acceptor belong to streams we opened
+	key := streamKey{id: id &^ acceptorBit, local: id&acceptorBit != 0}
+
+	c.streams.lock.Lock()
+	s, ok := c.streams.active[key]
+	if !ok && flags&flagSYN != 0 && !key.local {
+		s = newStream(c, key.id, false)
+		s.established = true
+		if err := s.receive(flags, data); err != nil {
+			c.streams.lock.Unlock()
+			c.lgr.Warnf("refusing stream %d, %s\n", key.id, err)
+			c.queueFrame(s.frame(flagRST, nil))
+			return
+		}
+		select {
+		case c.streams.accept <- s:
+			c.streams.active[key] = s
+		default:
+			c.streams.lock.Unlock()
+			c.lgr.Warnf("refusing stream %d, backlog full\n", key.id)
+			c.queueFrame(s.frame(flagRST, nil))
+			return
+		}
+		c.streams.lock.Unlock()
+		c.queueFrame(s.frame(flagACK, windowBytes(s.recvWindow)))
+		return
+	}
+	c.streams.lock.Unlock()
+
+	if !ok {
+		if flags&flagRST == 0 {
+			c.lgr.Debugf("dropping frame for unknown stream %d\n", key.id)
+		}
+		return
+	}
+
+	if err := s.receive(flags, data); err != nil {
+		c.lgr.Warnf("Resetting stream %d: %s", key.id, err)
+		if s.fail(ErrStreamReset) {
+			c.streams.remove(s)
+			c.queueFrame(s.frame(flagRST, nil))
+		}
+		return
+	}
+	if s.done() {
+		c.streams.remove(s)
+	}
+}
+
+// closeStreams fails all streams, used during shutdown
+func (c *conn) closeStreams() {
+	c.streams.lock.Lock()
+	active := c.streams.active
+	c.streams.active = make(map[streamKey]*stream)
+	c.streams.lock.Unlock()
+
+	for _, s := range active {
+		s.fail(ErrShutdown)
+	}
+}
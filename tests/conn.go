@@ -206,3 +206,79 @@ func DroppedMessages(t *testing.T, pool mux.Pool, newConn NewConn) {
 	mConn.Send(mux.LogType, "asdf")
 	mConn.Shutdown()
 }
+
+// SharedType tests sending the same Go type on different frame types
+func SharedType(t *testing.T, pool mux.Pool, newConn NewConn) {
+	client, server := connPair(t, newConn)
+	defer client.Shutdown()
+	defer server.Shutdown()
+
+	addType := uint8(10)
+	subType := uint8(11)
+	addCh := make(chan Args, 2)
+	subCh := make(chan Args, 2)
+	server.Receive(addType, pool.NewReceiver(addCh))
+	server.Receive(subType, pool.NewReceiver(subCh))
+
+	sent := []struct {
+		t    uint8
+		args Args
+	}{
+		{addType, Args{A: 1, B: 2}},
+		{subType, Args{A: 3, B: 4}},
+		{subType, Args{A: 5, B: 6}},
+		{addType, Args{A: 7, B: 8}},
+	}
+	for _, s := range sent {
+		if err := client.Send(s.t, s.args); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, s := range sent {
+		ch := addCh
+		if s.t == subType {
+			ch = subCh
+		}
+		select {
+		case actual := <-ch:
+			if actual != s.args {
+				t.Fatalf("actual %v != expected %v", actual, s.args)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%v not received on frame type %d", s.args, s.t)
+		}
+	}
+}
+
+// SendRaw tests sending data encoded ahead of time
+func SendRaw(t *testing.T, newConn NewConn) {
+	client, server := connPair(t, newConn)
+	defer client.Shutdown()
+	defer server.Shutdown()
+
+	logCh := make(chan string, 1)
+	server.Receive(mux.LogType, server.Pool().NewReceiver(logCh))
+
+	enc := client.Pool().NewBufferEncoder()
+	if err := enc.Encode("hello world"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SendRaw(mux.LogType, enc.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case actual := <-logCh:
+		if actual != "hello world" {
+			t.Fatalf("'%s' != 'hello world'", actual)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("raw frame not received")
+	}
+
+	client.Shutdown()
+	if err := client.SendRaw(mux.LogType, enc.Bytes()); err != mux.ErrShutdown {
+		t.Fatalf("expected %s, got %v", mux.ErrShutdown, err)
+	}
+}
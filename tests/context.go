@@ -0,0 +1,151 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/mux"
+)
+
+// Context tests cancelling Send, Recv and Shutdown with a context.Context
+func Context(t *testing.T, newConn NewConn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// A peer that never reads
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		time.Sleep(time.Second)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mConn, err := newConn(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mConn.Shutdown()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := mConn.SendContext(cancelled, mux.LogType, "hello world"); err != context.Canceled {
+		t.Fatalf("expected %s, got %v", context.Canceled, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	recvErr := make(chan error, 1)
+	go func() {
+		recvErr <- mConn.RecvContext(ctx)
+	}()
+	cancel()
+	select {
+	case err := <-recvErr:
+		if err != context.Canceled {
+			t.Fatalf("expected %s, got %v", context.Canceled, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("RecvContext not cancelled")
+	}
+
+	// Fill the socket buffers until Send blocks, a Send may also time out
+	// before it starts writing or find the connection already shutdown by
+	// the writer
+	text := strings.Repeat("hello world", 100000)
+	timeout := time.After(5 * time.Second)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err := mConn.SendContext(ctx, mux.LogType, text)
+		cancel()
+		if err != nil && err != context.DeadlineExceeded && err != mux.ErrShutdown {
+			t.Fatalf("expected %s, got %v", context.DeadlineExceeded, err)
+		}
+
+		select {
+		case <-mConn.IsShutdown():
+			return
+		case <-timeout:
+			t.Fatal("connection not shutdown after cancelling a partial write")
+		default:
+		}
+	}
+}
+
+// ShutdownContext tests a graceful shutdown bounded by a context.Context
+func ShutdownContext(t *testing.T, newConn NewConn) {
+	client, server := connPair(t, newConn)
+	defer server.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := client.ShutdownContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	<-server.IsShutdown()
+	expected := mux.CloseReason{Code: mux.CloseNormal, Remote: true}
+	if actual := server.CloseReason(); actual != expected {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+}
+
+// WaitContext tests giving up on a server that never finishes
+func WaitContext(t *testing.T, newServer NewServer, newClient NewClient) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	done := make(chan struct{})
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		server, err := newServer(conn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		go server.Recv()
+
+		<-done
+		server.Done(nil)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := newClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go client.Recv()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := client.WaitContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected %s, got %v", context.DeadlineExceeded, err)
+	}
+
+	// The connection is still usable
+	close(done)
+	if err := client.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,195 @@
+package tests
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/mux"
+)
+
+// Fragments tests sending large messages as fragments interleaved with other
+// frames and reassembling them up to the Config's MaxMessageSize
+func Fragments(t *testing.T, pool mux.Pool, newConn NewConfigConn) {
+	// Messages span several frames unless configured otherwise
+	if mux.DefaultMaxMessageSize <= mux.MaxFrameSize {
+		t.Fatalf("default max message size %d fits in a frame", mux.DefaultMaxMessageSize)
+	}
+
+	client, server := configPair(t, newConn, &mux.Config{
+		Timeout:      time.Second,
+		Lager:        Lager(),
+		FragmentSize: 1024,
+	}, &mux.Config{
+		Timeout:        time.Second,
+		Lager:          Lager(),
+		MaxMessageSize: 64 * 1024,
+	})
+	defer client.Shutdown()
+	defer server.Shutdown()
+
+	logCh := make(chan string, 4)
+	server.Receive(mux.LogType, pool.NewReceiver(logCh))
+	signalCh := make(chan string, 100)
+	server.Receive(mux.SignalType, pool.NewReceiver(signalCh))
+
+	large := strings.Repeat("hello world", 5000)
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Send(mux.LogType, large)
+	}()
+	for i := 0; i < 100; i++ {
+		if err := client.Send(mux.SignalType, "signal"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case actual := <-logCh:
+		if actual != large {
+			t.Fatalf("expected %d bytes, got %d", len(large), len(actual))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+	for i := 0; i < 100; i++ {
+		select {
+		case <-signalCh:
+		case <-time.After(time.Second):
+			t.Fatal("signal not received")
+		}
+	}
+
+	// Too large for the server, it drops the message and carries on
+	if err := client.Send(mux.LogType, strings.Repeat("hello world", 10000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Send(mux.LogType, "hello world"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case actual := <-logCh:
+		if actual != "hello world" {
+			t.Fatalf("expected hello world, got %d bytes", len(actual))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+
+	// Too large to send
+	if err := server.Send(mux.LogType, strings.Repeat("hello world", 10000)); err != mux.ErrMessageTooLarge {
+		t.Fatalf("expected %s, got %v", mux.ErrMessageTooLarge, err)
+	}
+
+	// Fragments are flagged on the wire and an aborted message is dropped
+	mConn, peer := rawPair(t, func(conn net.Conn) (mux.Conn, error) {
+		return newConn(conn, &mux.Config{
+			Timeout:      time.Second,
+			Lager:        Lager(),
+			FragmentSize: 16,
+		})
+	})
+	defer mConn.Shutdown()
+	rawCh := make(chan string, 2)
+	mConn.Receive(mux.LogType, pool.NewReceiver(rawCh))
+	go mConn.Recv()
+	if _, err := peer.Write([]byte{'M', 'U', 'X', 2, byte(mux.AllCapabilities)}); err != nil {
+		t.Fatal(err)
+	}
+	mConn.Capabilities()
+	if err := mConn.Send(mux.LogType, strings.Repeat("hello world", 10)); err != nil {
+		t.Fatal(err)
+	}
+	header := make([]byte, 6)
+	if _, err := io.ReadFull(peer, header[:5]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(peer, header); err != nil {
+		t.Fatal(err)
+	}
+	if header[0] != 2 || header[2] != 16 {
+		t.Fatalf("expected a 16 byte fragment, got header %v", header[:3])
+	}
+
+	enc := pool.NewBufferEncoder()
+	enc.Encode("hello world")
+	data := enc.Bytes()
+	var frames []byte
+	frames = appendRawFrame(frames, 2, data[:len(data)/2])
+	frames = appendRawFrame(frames, 4, nil)
+	frames = appendRawFrame(frames, 2, data[:len(data)/2])
+	frames = appendRawFrame(frames, 0, data[len(data)/2:])
+	if _, err := peer.Write(frames); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case actual := <-rawCh:
+		if actual != "hello world" {
+			t.Fatalf("'%s' != 'hello world'", actual)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+	select {
+	case actual := <-rawCh:
+		t.Fatalf("unexpected %q", actual)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// SendAfterTooLarge tests a type's messages still decode after one too large
+// to send, with stateful Pools such as gob's the encoder encoded it already
+func SendAfterTooLarge(t *testing.T, pool mux.Pool) {
+	client, server := connPair(t, func(conn net.Conn) (mux.Conn, error) {
+		return mux.NewConn(conn, pool, &mux.Config{
+			Timeout:        time.Second,
+			Lager:          Lager(),
+			MaxMessageSize: 1000,
+		})
+	})
+	defer client.Shutdown()
+	defer server.Shutdown()
+
+	type record struct {
+		Data string
+	}
+	recordCh := make(chan record, 2)
+	server.Receive(mux.LogType, pool.NewReceiver(recordCh))
+
+	// Random so it's too large even compressed
+	large := make([]byte, 1000)
+	rand.Read(large)
+
+	// First before the type was ever sent, then after
+	for i := 0; i < 2; i++ {
+		if err := client.Send(mux.LogType, record{hex.EncodeToString(large)}); err != mux.ErrMessageTooLarge {
+			t.Fatalf("%d: expected %s, got %v", i, mux.ErrMessageTooLarge, err)
+		}
+		if err := client.Send(mux.LogType, record{"small"}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case actual := <-recordCh:
+			if actual.Data != "small" {
+				t.Fatalf("%d: expected small, got %d bytes", i, len(actual.Data))
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%d: message not received after one too large", i)
+		}
+	}
+}
+
+// appendRawFrame appends a LogType frame with flags in wire format to b
+func appendRawFrame(b []byte, flags uint8, data []byte) []byte {
+	b = append(b, flags)
+	b = binary.AppendUvarint(b, uint64(mux.LogType))
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
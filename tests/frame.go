@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/doubledutch/mux"
+)
+
+// WireFormat tests the hello and frame header written to and read from a raw
+// peer
+func WireFormat(t *testing.T, newConn NewConn) {
+	for _, tc := range []struct {
+		preamble []byte
+		expected error
+	}{
+		{[]byte("GET / HTTP/1.1\r\n"), mux.ErrInvalidPreamble},
+		{[]byte{'M', 'U', 'X', 0}, mux.ErrUnsupportedVersion},
+	} {
+		mConn, peer := rawPair(t, newConn)
+		if _, err := peer.Write(tc.preamble); err != nil {
+			t.Fatal(err)
+		}
+		if err := mConn.RecvContext(context.Background()); err != tc.expected {
+			t.Fatalf("expected %s, got %v", tc.expected, err)
+		}
+		if err := mConn.Err(); err != tc.expected {
+			t.Fatalf("expected %s, got %v", tc.expected, err)
+		}
+	}
+
+	mConn, peer := rawPair(t, newConn)
+	defer mConn.Shutdown()
+
+	if err := mConn.Send(mux.LogType, "hello world"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{'M', 'U', 'X', 2, byte(offered(mConn)), 0, mux.LogType}
+	actual := make([]byte, len(expected))
+	if _, err := io.ReadFull(peer, actual); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+
+	// A frame split across writes arrives whole, after a version 1 hello
+	logCh := make(chan string, 1)
+	mConn.Receive(mux.LogType, mConn.Pool().NewReceiver(logCh))
+	go mConn.Recv()
+
+	enc := mConn.Pool().NewBufferEncoder()
+	if err := enc.Encode("hello world"); err != nil {
+		t.Fatal(err)
+	}
+	frame := append([]byte{'M', 'U', 'X', 1, 0, mux.LogType, byte(enc.Len())}, enc.Bytes()...)
+	for _, b := range frame {
+		if _, err := peer.Write([]byte{b}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if actual := <-logCh; actual != "hello world" {
+		t.Fatalf("'%s' != 'hello world'", actual)
+	}
+	if caps := mConn.Capabilities(); caps != mux.CapHeartbeat|mux.CapStreams|mux.CapGoAway {
+		t.Fatalf("expected version 1 capabilities, got %b", caps)
+	}
+}
+
+// rawPair returns a Conn and the raw net.Conn of its peer
+func rawPair(t *testing.T, newConn NewConn) (mux.Conn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	mConn, err := newConn(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mConn, peer
+}
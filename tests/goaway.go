@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/doubledutch/mux"
+)
+
+// GoAway tests that a graceful shutdown delivers every frame sent before it
+// and tells the peer why
+func GoAway(t *testing.T, pool mux.Pool, newConn NewConn) {
+	client, server := connPair(t, newConn)
+
+	max := 1000
+	logCh := make(chan string, max)
+	client.Receive(mux.LogType, pool.NewReceiver(logCh))
+
+	// The server sends until it learns the client is going away
+	sent := make(chan int, 1)
+	go func() {
+		n := 0
+		for ; n < max; n++ {
+			if err := server.Send(mux.LogType, "hello world"); err != nil {
+				if err != mux.ErrGoingAway {
+					t.Error(err)
+				}
+				break
+			}
+		}
+		sent <- n
+	}()
+
+	time.Sleep(time.Millisecond)
+	if err := client.GoAway(mux.CloseGoingAway, "restarting"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Send(mux.LogType, "too late"); err != mux.ErrGoingAway {
+		t.Fatalf("expected %s, got %v", mux.ErrGoingAway, err)
+	}
+
+	select {
+	case <-server.IsShutdown():
+	case <-time.After(time.Second):
+		t.Fatal("server not shutdown after goaway")
+	}
+
+	n := <-sent
+	received := 0
+	for range logCh {
+		received++
+	}
+	if received != n {
+		t.Fatalf("server sent %d frames, client received %d", n, received)
+	}
+
+	expected := mux.CloseReason{Code: mux.CloseGoingAway, Message: "restarting", Remote: true}
+	if actual := server.CloseReason(); actual != expected {
+		t.Fatalf("expected server close reason %v, got %v", expected, actual)
+	}
+	expected.Remote = false
+	if actual := client.CloseReason(); actual != expected {
+		t.Fatalf("expected client close reason %v, got %v", expected, actual)
+	}
+}
+
+// ConnLost tests that a peer disconnecting without a goaway is abnormal
+func ConnLost(t *testing.T, newConn NewConn) {
+	client, server := connPair(t, newConn)
+	defer server.Shutdown()
+
+	if reason := server.CloseReason(); reason.Code != mux.CloseNone {
+		t.Fatalf("expected open connection, got %v", reason)
+	}
+
+	client.Shutdown()
+	if reason := client.CloseReason(); reason.Code != mux.CloseNormal {
+		t.Fatalf("expected normal close, got %v", reason)
+	}
+
+	select {
+	case <-server.IsShutdown():
+	case <-time.After(time.Second):
+		t.Fatal("server not shutdown after connection lost")
+	}
+	if err := server.Err(); err != mux.ErrConnLost {
+		t.Fatalf("expected %s, got %v", mux.ErrConnLost, err)
+	}
+	if reason := server.CloseReason(); reason.Code != mux.CloseAbnormal {
+		t.Fatalf("expected abnormal close, got %v", reason)
+	}
+}
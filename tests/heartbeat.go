@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/mux"
+)
+
+// Heartbeat tests measuring round-trip time and detecting a dead peer
+func Heartbeat(t *testing.T, newConn NewConfigConn) {
+	// Peers that never Recv or predate heartbeats must not time out
+	if interval := mux.DefaultConfig().PingInterval; interval != 0 {
+		t.Fatalf("heartbeats on by default, every %s", interval)
+	}
+
+	config := &mux.Config{
+		Timeout:        time.Second,
+		Lager:          Lager(),
+		PingInterval:   10 * time.Millisecond,
+		MaxMissedPings: 2,
+	}
+	newDefaultConn := func(conn net.Conn) (mux.Conn, error) {
+		return newConn(conn, config)
+	}
+
+	client, server := connPair(t, newDefaultConn)
+	defer server.Shutdown()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		last, smoothed := client.RTT()
+		if last > 0 && smoothed > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no round-trip time measured")
+		}
+		time.Sleep(config.PingInterval)
+	}
+	client.Shutdown()
+
+	// A peer that never answers
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		time.Sleep(time.Second)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mConn, err := newDefaultConn(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go mConn.Recv()
+
+	select {
+	case <-mConn.IsShutdown():
+	case <-time.After(time.Second):
+		t.Fatal("dead peer not detected")
+	}
+	if err := mConn.Err(); err != mux.ErrHeartbeatTimeout {
+		t.Fatalf("expected %s, got %v", mux.ErrHeartbeatTimeout, err)
+	}
+
+	// A peer that stops reading, leaving the writer stuck
+	stuck, peer := net.Pipe()
+	defer peer.Close()
+	mConn, err = newDefaultConn(stuck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go mConn.Recv()
+
+	select {
+	case <-mConn.IsShutdown():
+	case <-time.After(time.Second):
+		t.Fatal("dead peer not detected while writing")
+	}
+	if err := mConn.Err(); err != mux.ErrHeartbeatTimeout {
+		t.Fatalf("expected %s, got %v", mux.ErrHeartbeatTimeout, err)
+	}
+}
@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/mux"
+)
+
+// Capabilities tests negotiating capabilities and turning off those a peer
+// doesn't support
+func Capabilities(t *testing.T, newConn NewConfigConn) {
+	client, server := configPair(t, newConn, &mux.Config{
+		Timeout: time.Second,
+		Lager:   Lager(),
+	}, &mux.Config{
+		Timeout: time.Second,
+		Lager:   Lager(),
+	})
+	all := offered(client)
+	if caps := client.Capabilities(); caps != all {
+		t.Fatalf("expected %b, got %b", all, caps)
+	}
+	if caps := server.Capabilities(); caps != all {
+		t.Fatalf("expected %b, got %b", all, caps)
+	}
+	client.Shutdown()
+	server.Shutdown()
+
+	// A client without streams, heartbeats or GOAWAY
+	disabled := mux.CapStreams | mux.CapHeartbeat | mux.CapGoAway
+	client, server = configPair(t, newConn, &mux.Config{
+		Timeout:             time.Second,
+		Lager:               Lager(),
+		DisableCapabilities: disabled,
+	}, &mux.Config{
+		Timeout:        time.Second,
+		Lager:          Lager(),
+		PingInterval:   10 * time.Millisecond,
+		MaxMissedPings: 1,
+	})
+	defer client.Shutdown()
+	if caps := server.Capabilities(); caps != all&^disabled {
+		t.Fatalf("expected %b, got %b", all&^disabled, caps)
+	}
+	if caps := client.Capabilities(); caps != all&^disabled {
+		t.Fatalf("expected %b, got %b", all&^disabled, caps)
+	}
+	if _, err := client.OpenStream(); err != mux.ErrStreamsUnsupported {
+		t.Fatalf("expected %s, got %v", mux.ErrStreamsUnsupported, err)
+	}
+	if _, err := server.OpenStream(); err != mux.ErrStreamsUnsupported {
+		t.Fatalf("expected %s, got %v", mux.ErrStreamsUnsupported, err)
+	}
+
+	// Pings would go unanswered
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-server.IsShutdown():
+		t.Fatalf("server shutdown: %v", server.Err())
+	default:
+	}
+
+	// No acknowledgement to wait for
+	done := make(chan error, 1)
+	go func() {
+		done <- server.GoAway(mux.CloseNormal, "")
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("GoAway waited for the peer")
+	}
+
+	// Offering only the capabilities of version 1 sends a version 1 hello,
+	// which peers predating capabilities can read
+	legacy := mux.CapHeartbeat | mux.CapStreams | mux.CapGoAway
+	mConn, peer := rawPair(t, func(conn net.Conn) (mux.Conn, error) {
+		return newConn(conn, &mux.Config{
+			Timeout:             time.Second,
+			Lager:               Lager(),
+			DisableCapabilities: mux.AllCapabilities &^ legacy,
+		})
+	})
+	defer mConn.Shutdown()
+	hello := make([]byte, 5)
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(peer, hello[:4]); err != nil {
+		t.Fatal(err)
+	}
+	if string(hello[:4]) != "MUX\x01" {
+		t.Fatalf("expected a version 1 hello, got %q", hello[:4])
+	}
+	peer.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if n, err := peer.Read(hello[4:]); n != 0 {
+		t.Fatalf("expected nothing after the hello, got %v %v", hello[4:], err)
+	}
+}
+
+// offered returns the capabilities conn offers by default, CapCompression
+// only with a CompressingPool
+func offered(conn mux.Conn) mux.Capabilities {
+	if _, ok := conn.Pool().(mux.CompressingPool); ok {
+		return mux.AllCapabilities
+	}
+	return mux.AllCapabilities &^ mux.CapCompression
+}
+
+// configPair returns two connected mux.Conns with Recv running on both
+func configPair(t *testing.T, newConn NewConfigConn, clientConfig, serverConfig *mux.Config) (mux.Conn, mux.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := newConn(conn, clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := newConn(accepted, serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go client.Recv()
+	go server.Recv()
+	return client, server
+}
@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/mux"
+)
+
+// Negotiate tests negotiating the encoding registered as name
+func Negotiate(t *testing.T, name string) {
+	pool := mux.DefaultRegistry.Pool(name)
+	if pool == nil {
+		t.Fatalf("encoding %s not registered", name)
+	}
+
+	// The client prefers an encoding the server doesn't have
+	clientRegistry := mux.NewRegistry()
+	clientRegistry.Register("unknown", pool)
+	clientRegistry.Register(name, pool)
+	clientConfig := negotiateConfig()
+	clientConfig.Registry = clientRegistry
+	clientConfig.Encodings = []string{"unknown", name}
+
+	client, server, clientErr, serverErr := negotiatePair(t, clientConfig, negotiateConfig())
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+	if serverErr != nil {
+		t.Fatal(serverErr)
+	}
+	defer client.Shutdown()
+	defer server.Shutdown()
+
+	if client.Pool() != pool || server.Pool() != pool {
+		t.Fatalf("expected the %s Pool", name)
+	}
+
+	logCh := make(chan string, 1)
+	server.Receive(mux.LogType, server.Pool().NewReceiver(logCh))
+	go server.Recv()
+	if err := client.Send(mux.LogType, "hello world"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case actual := <-logCh:
+		if actual != "hello world" {
+			t.Fatalf("'%s' != 'hello world'", actual)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("frame not received")
+	}
+
+	// Nothing in common
+	serverRegistry := mux.NewRegistry()
+	serverRegistry.Register(name, pool)
+	clientConfig = negotiateConfig()
+	clientConfig.Registry = clientRegistry
+	clientConfig.Encodings = []string{"unknown"}
+	serverConfig := negotiateConfig()
+	serverConfig.Registry = serverRegistry
+
+	_, _, clientErr, serverErr = negotiatePair(t, clientConfig, serverConfig)
+	if clientErr != mux.ErrNoCommonEncoding {
+		t.Fatalf("expected %s, got %v", mux.ErrNoCommonEncoding, clientErr)
+	}
+	if serverErr != mux.ErrNoCommonEncoding {
+		t.Fatalf("expected %s, got %v", mux.ErrNoCommonEncoding, serverErr)
+	}
+
+	// An encoding that isn't registered
+	clientConfig.Registry = serverRegistry
+	if _, err := mux.NewClientConn(nil, clientConfig); err != mux.ErrInvalidEncodings {
+		t.Fatalf("expected %s, got %v", mux.ErrInvalidEncodings, err)
+	}
+
+	// More encodings registered than a handshake can offer
+	crowdedRegistry := mux.NewRegistry()
+	for i := 0; i < 256; i++ {
+		crowdedRegistry.Register(fmt.Sprintf("%s-%d", name, i), pool)
+	}
+	clientConfig = negotiateConfig()
+	clientConfig.Registry = crowdedRegistry
+	if _, err := mux.NewClientConn(nil, clientConfig); err != mux.ErrInvalidEncodings {
+		t.Fatalf("expected %s, got %v", mux.ErrInvalidEncodings, err)
+	}
+
+	// A client that doesn't negotiate
+	mConn, peer := rawPair(t, func(conn net.Conn) (mux.Conn, error) {
+		return mux.NewConn(conn, pool, negotiateConfig())
+	})
+	defer mConn.Shutdown()
+	if err := mConn.Send(mux.LogType, "hello world"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mux.NewServerConn(peer, negotiateConfig()); err != mux.ErrInvalidHandshake {
+		t.Fatalf("expected %s, got %v", mux.ErrInvalidHandshake, err)
+	}
+}
+
+func negotiateConfig() *mux.Config {
+	return &mux.Config{
+		Timeout:          time.Second,
+		Lager:            Lager(),
+		HandshakeTimeout: time.Second,
+	}
+}
+
+// negotiatePair returns the results of negotiating over TCP
+func negotiatePair(t *testing.T, clientConfig, serverConfig *mux.Config) (mux.Conn, mux.Conn, error, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	type result struct {
+		conn mux.Conn
+		err  error
+	}
+	accepted := make(chan result, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			accepted <- result{err: err}
+			return
+		}
+		mConn, err := mux.NewServerConn(conn, serverConfig)
+		if err != nil {
+			conn.Close()
+		}
+		accepted <- result{mConn, err}
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, clientErr := mux.NewClientConn(conn, clientConfig)
+	if clientErr != nil {
+		conn.Close()
+	}
+
+	r := <-accepted
+	return client, r.conn, clientErr, r.err
+}
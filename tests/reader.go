@@ -0,0 +1,172 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/mux"
+)
+
+const readerType = uint8(10)
+
+// streamResult is what reading a stream returned
+type streamResult struct {
+	data []byte
+	err  error
+}
+
+// SendStream tests streaming readers from a server, with the result of Wait
+// ordered after the streams
+func SendStream(t *testing.T, newServer NewServer, newClient NewClient) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	data := make([]byte, 300*1024)
+	rand.Read(data)
+	failed := errors.New("disk on fire")
+
+	// server
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		server, err := newServer(conn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		go server.Recv()
+
+		if err := server.SendStream(readerType, bytes.NewReader(data)); err != nil {
+			t.Error(err)
+		}
+		r := io.MultiReader(bytes.NewReader(data[:1000]), &failingReader{err: failed})
+		if err := server.SendStream(readerType, r); err != failed {
+			t.Errorf("expected %s, got %v", failed, err)
+		}
+		server.Done(nil)
+	}()
+
+	// client
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := newClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streams := make(chan io.ReadCloser, 2)
+	client.Receive(readerType, mux.NewReaderReceiver(streams))
+	go client.Recv()
+
+	results := make(chan streamResult, 2)
+	go func() {
+		for stream := range streams {
+			b, err := ioutil.ReadAll(stream)
+			results <- streamResult{b, err}
+		}
+	}()
+
+	if err := client.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expected := range []streamResult{{data, nil}, {data[:1000], failed}} {
+		select {
+		case actual := <-results:
+			if !bytes.Equal(actual.data, expected.data) {
+				t.Fatalf("stream %d: expected %d bytes, got %d", i, len(expected.data), len(actual.data))
+			}
+			if (actual.err == nil) != (expected.err == nil) || (actual.err != nil && actual.err.Error() != expected.err.Error()) {
+				t.Fatalf("stream %d: expected %v, got %v", i, expected.err, actual.err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("stream %d not read", i)
+		}
+	}
+}
+
+// ReaderReceiver tests closing streams before they end and shutting down
+// part way through one
+func ReaderReceiver(t *testing.T, newConn NewConn) {
+	client, server := connPair(t, newConn)
+	defer server.Shutdown()
+
+	streams := make(chan io.ReadCloser, 1)
+	server.Receive(readerType, mux.NewReaderReceiver(streams))
+
+	// The rest of a closed stream is dropped without holding up Recv
+	done := make(chan error, 1)
+	go func() {
+		done <- client.SendStream(readerType, bytes.NewReader(make([]byte, 2*1024*1024)))
+	}()
+	stream := receiveStream(t, streams)
+	stream.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.SendStream(readerType, bytes.NewBufferString("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(receiveStream(t, streams))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello world" {
+		t.Fatalf("'%s' != 'hello world'", b)
+	}
+
+	// The connection closes part way through
+	pr, pw := io.Pipe()
+	go func() {
+		done <- client.SendStream(readerType, pr)
+	}()
+	if _, err := pw.Write([]byte("partial")); err != nil {
+		t.Fatal(err)
+	}
+	stream = receiveStream(t, streams)
+	b = make([]byte, len("partial"))
+	if _, err := io.ReadFull(stream, b); err != nil {
+		t.Fatal(err)
+	}
+	client.Shutdown()
+	pw.Close()
+	if _, err := stream.Read(b); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected %s, got %v", io.ErrUnexpectedEOF, err)
+	}
+	if err := <-done; err == nil {
+		t.Fatal("expected SendStream to fail")
+	}
+}
+
+func receiveStream(t *testing.T, streams chan io.ReadCloser) io.ReadCloser {
+	select {
+	case stream := <-streams:
+		return stream
+	case <-time.After(time.Second):
+		t.Fatal("stream not received")
+		return nil
+	}
+}
+
+// failingReader returns err from every Read
+type failingReader struct {
+	err error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
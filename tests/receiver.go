@@ -52,3 +52,49 @@ func SignalReceiver(t *testing.T, pool mux.Pool) {
 		t.Fatalf("actual '%v' != expected '%v'", actual, expected)
 	}
 }
+
+// DroppedFrame tests receiving a frame after an earlier frame of the same
+// type was dropped, which requires a self-describing mux.Pool
+func DroppedFrame(t *testing.T, pool mux.Pool) {
+	ch := make(chan Args, 1)
+	r := pool.NewReceiver(ch)
+	defer r.Close()
+
+	enc := pool.NewBufferEncoder()
+	if err := enc.Encode(&Args{A: 1, B: 2}); err != nil {
+		t.Fatal(err)
+	}
+	enc.Reset()
+
+	expected := Args{A: 3, B: 4}
+	if err := enc.Encode(&expected); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Receive(enc.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	if actual := <-ch; actual != expected {
+		t.Fatalf("actual %v != expected %v", actual, expected)
+	}
+}
+
+// BytesReceiver tests receiving frame data as is with a mux.Pool
+func BytesReceiver(t *testing.T, pool mux.Pool) {
+	ch := make(chan []byte, 1)
+	r := pool.NewReceiver(ch)
+	defer r.Close()
+
+	expected := []byte("hello world")
+	if err := r.Receive(expected); err != nil {
+		t.Fatal(err)
+	}
+
+	actual := <-ch
+	if string(actual) != string(expected) {
+		t.Fatalf("actual '%s' != expected '%s'", actual, expected)
+	}
+	if &actual[0] != &expected[0] {
+		t.Fatal("frame data was copied")
+	}
+}
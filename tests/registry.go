@@ -0,0 +1,185 @@
+package tests
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/mux"
+)
+
+// blockingReceiver puts frames on ch, waiting for release first if set, and
+// closes closed
+type blockingReceiver struct {
+	ch      chan string
+	release chan struct{}
+	closed  chan struct{}
+}
+
+func newBlockingReceiver(release chan struct{}) *blockingReceiver {
+	return &blockingReceiver{
+		ch:      make(chan string, 100),
+		release: release,
+		closed:  make(chan struct{}),
+	}
+}
+
+func (r *blockingReceiver) Receive(b []byte) error {
+	r.ch <- string(b)
+	if r.release != nil {
+		<-r.release
+	}
+	return nil
+}
+
+func (r *blockingReceiver) Close() error {
+	close(r.closed)
+	return nil
+}
+
+// callbackReceiver calls close when it's closed
+type callbackReceiver struct {
+	close func()
+}
+
+func (r callbackReceiver) Receive(b []byte) error {
+	return nil
+}
+
+func (r callbackReceiver) Close() error {
+	r.close()
+	return nil
+}
+
+// Registry tests receivers are registered, replaced and unregistered while
+// Recv is running, closing them once their in-flight Receive returns
+func Registry(t *testing.T, newConn NewConn) {
+	client, server := connPair(t, newConn)
+	defer client.Shutdown()
+	defer server.Shutdown()
+
+	expect := func(ch chan string, expected string) {
+		select {
+		case actual := <-ch:
+			if actual != expected {
+				t.Fatalf("expected %s, got %s", expected, actual)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s not received", expected)
+		}
+	}
+	isClosed := func(r *blockingReceiver) bool {
+		select {
+		case <-r.closed:
+			return true
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}
+
+	// Registered while frames are received
+	first := newBlockingReceiver(nil)
+	server.Receive(mux.LogType, first)
+	if err := client.SendRaw(mux.LogType, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	expect(first.ch, "first")
+	if r := server.Receivers()[mux.LogType]; r != first {
+		t.Fatalf("expected %v, got %v", first, r)
+	}
+
+	// Replaced, closing the first
+	release := make(chan struct{})
+	second := newBlockingReceiver(release)
+	server.Replace(mux.LogType, second)
+	if !isClosed(first) {
+		t.Fatal("replaced receiver not closed")
+	}
+	if err := client.SendRaw(mux.LogType, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	expect(second.ch, "second")
+
+	// Unregistered in the middle of Receive, closed once it returns
+	server.Unregister(mux.LogType)
+	if _, ok := server.Receivers()[mux.LogType]; ok {
+		t.Fatal("receiver still registered")
+	}
+	if isClosed(second) {
+		t.Fatal("receiver closed during Receive")
+	}
+	close(release)
+	if !isClosed(second) {
+		t.Fatal("unregistered receiver not closed")
+	}
+
+	// Dropped once unregistered
+	if err := client.SendRaw(mux.LogType, []byte("dropped")); err != nil {
+		t.Fatal(err)
+	}
+	third := newBlockingReceiver(nil)
+	server.Receive(mux.SignalType, third)
+	if err := client.SendRaw(mux.SignalType, []byte("third")); err != nil {
+		t.Fatal(err)
+	}
+	expect(third.ch, "third")
+	select {
+	case actual := <-second.ch:
+		t.Fatalf("%s received once unregistered", actual)
+	default:
+	}
+
+	// Receivers closed by Shutdown can call back into the Conn
+	closed := make(chan struct{})
+	server.Receive(mux.ErrType, callbackReceiver{func() {
+		server.Receivers()
+		server.Unregister(mux.SignalType)
+		server.Receive(mux.SignalType, newBlockingReceiver(nil))
+		server.Err()
+		close(closed)
+	}})
+	done := make(chan struct{})
+	go func() {
+		server.Shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown deadlocked closing a receiver")
+	}
+	select {
+	case <-closed:
+	default:
+		t.Fatal("receiver not closed by Shutdown")
+	}
+}
+
+// ReplaceReceiver tests a replacing receiver decodes frames of a type already
+// received, which stateful Pools need to be self-describing for
+func ReplaceReceiver(t *testing.T, pool mux.Pool) {
+	client, server := connPair(t, func(conn net.Conn) (mux.Conn, error) {
+		return mux.NewConn(conn, pool, mux.DefaultConfig())
+	})
+	defer client.Shutdown()
+	defer server.Shutdown()
+
+	type log struct {
+		Line string
+	}
+	for i, line := range []string{"first", "second"} {
+		logCh := make(chan log, 1)
+		server.Replace(mux.LogType, pool.NewReceiver(logCh))
+		if err := client.Send(mux.LogType, log{line}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case actual := <-logCh:
+			if actual.Line != line {
+				t.Fatalf("%d: expected %s, got %s", i, line, actual.Line)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%d: %s not received", i, line)
+		}
+	}
+}
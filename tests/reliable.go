@@ -0,0 +1,173 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/mux"
+)
+
+// flakyReceiver fails the next fails frames, every frame if negative, and
+// can be slowed down
+type flakyReceiver struct {
+	mux.Receiver
+
+	lock  sync.Mutex
+	fails int
+	delay time.Duration
+}
+
+func (r *flakyReceiver) Receive(b []byte) error {
+	r.lock.Lock()
+	delay := r.delay
+	fail := r.fails != 0
+	if r.fails > 0 {
+		r.fails--
+	}
+	r.lock.Unlock()
+
+	time.Sleep(delay)
+	if fail {
+		return errors.New("flaky")
+	}
+	return r.Receiver.Receive(b)
+}
+
+// Reliable tests frames of reliable types are redelivered until the peer's
+// Receiver accepts them, on a new Conn if need be, and only received once
+func Reliable(t *testing.T, pool mux.Pool) {
+	clientReliable := mux.NewReliable(20 * time.Millisecond)
+	if err := clientReliable.Add(mux.LogType); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientReliable.Add(mux.StreamType); err != mux.ErrReservedType {
+		t.Fatalf("expected %s, got %v", mux.ErrReservedType, err)
+	}
+	serverReliable := mux.NewReliable(0)
+
+	// The server's Receiver is registered before Recv, redelivered frames
+	// arrive right after the hello
+	pair := func(r mux.Receiver) (mux.Conn, mux.Conn) {
+		newConn := func(conn net.Conn, config *mux.Config) (mux.Conn, error) {
+			c, err := mux.NewConn(conn, pool, config)
+			if err == nil && config.Reliable == serverReliable {
+				c.Receive(mux.LogType, r)
+			}
+			return c, err
+		}
+		return configPair(t, newConn, &mux.Config{
+			Timeout:  time.Second,
+			Lager:    Lager(),
+			Reliable: clientReliable,
+		}, &mux.Config{
+			Timeout:  time.Second,
+			Lager:    Lager(),
+			Reliable: serverReliable,
+		})
+	}
+	receive := func(ch chan string, expected string) {
+		select {
+		case actual := <-ch:
+			if actual != expected {
+				t.Fatalf("expected %s, got %s", expected, actual)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s not received", expected)
+		}
+		select {
+		case actual := <-ch:
+			t.Fatalf("%s received again", actual)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	logCh := make(chan string, 10)
+	r := &flakyReceiver{
+		Receiver: pool.NewReceiver(logCh),
+		fails:    1,
+	}
+	client, server := pair(r)
+
+	if _, err := client.SendReliable(ctx, mux.SignalType, "signal"); err != mux.ErrNotReliable {
+		t.Fatalf("expected %s, got %v", mux.ErrNotReliable, err)
+	}
+
+	// Redelivered after the Receiver fails
+	d, err := client.SendReliable(ctx, mux.LogType, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	receive(logCh, "hello")
+
+	// Redelivered while the Receiver is slow, the duplicates are dropped
+	r.lock.Lock()
+	r.delay = 100 * time.Millisecond
+	r.lock.Unlock()
+	d, err = client.SendReliable(ctx, mux.LogType, "slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	receive(logCh, "slow")
+
+	// Redelivered on a new Conn
+	r.lock.Lock()
+	r.delay = 0
+	r.fails = -1
+	r.lock.Unlock()
+	d, err = client.SendReliable(ctx, mux.LogType, "again")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Shutdown()
+	server.Shutdown()
+	select {
+	case <-d.Acked():
+		t.Fatal("frame acknowledged by a failing Receiver")
+	default:
+	}
+
+	againCh := make(chan string, 10)
+	client, server = pair(pool.NewReceiver(againCh))
+	defer client.Shutdown()
+	defer server.Shutdown()
+	if err := d.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	receive(againCh, "again")
+}
+
+// ReliableUnsupported tests sending a reliable type to a peer without
+// CapReliable fails
+func ReliableUnsupported(t *testing.T, newConn NewConfigConn) {
+	reliable := mux.NewReliable(0)
+	if err := reliable.Add(mux.LogType); err != nil {
+		t.Fatal(err)
+	}
+	client, server := configPair(t, newConn, &mux.Config{
+		Timeout:  time.Second,
+		Lager:    Lager(),
+		Reliable: reliable,
+	}, &mux.Config{
+		Timeout:             time.Second,
+		Lager:               Lager(),
+		DisableCapabilities: mux.CapReliable,
+	})
+	defer client.Shutdown()
+	defer server.Shutdown()
+
+	if _, err := client.SendReliable(context.Background(), mux.LogType, "hello"); err != mux.ErrReliableUnsupported {
+		t.Fatalf("expected %s, got %v", mux.ErrReliableUnsupported, err)
+	}
+}
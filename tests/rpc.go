@@ -0,0 +1,191 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net"
+	netrpc "net/rpc"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/mux"
+	"github.com/doubledutch/mux/rpc"
+)
+
+// Args are the arguments to RPC methods
+type Args struct {
+	A, B int
+}
+
+// RPC tests calls between an rpc.Client and rpc.Server
+func RPC(t *testing.T, newConn NewConn) {
+	clientConn, serverConn := connPair(t, newConn)
+	defer clientConn.Shutdown()
+	defer serverConn.Shutdown()
+
+	rpcType := uint8(10)
+	expectedErr := errors.New("division by zero")
+
+	server := rpc.NewServer(serverConn, rpcType)
+	server.Handle("Add", func(ctx context.Context, args *Args, reply *int) error {
+		*reply = args.A + args.B
+		return nil
+	})
+	server.Handle("Divide", func(ctx context.Context, args *Args, reply *int) error {
+		if args.B == 0 {
+			return expectedErr
+		}
+		*reply = args.A / args.B
+		return nil
+	})
+	server.Handle("Block", func(ctx context.Context, args *Args, reply *int) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	client := rpc.NewClient(clientConn, rpcType)
+	ctx := context.Background()
+
+	// concurrent calls
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var sum int
+			if err := client.Call(ctx, "Add", &Args{A: i, B: i}, &sum); err != nil {
+				t.Error(err)
+			} else if sum != 2*i {
+				t.Errorf("%d + %d = %d", i, i, sum)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var quo int
+	err := client.Call(ctx, "Divide", &Args{A: 1}, &quo)
+	if _, ok := err.(rpc.ServerError); !ok || err.Error() != expectedErr.Error() {
+		t.Fatalf("expected ServerError '%s', got %v", expectedErr, err)
+	}
+
+	if err := client.Call(ctx, "Missing", &Args{}, &quo); err == nil {
+		t.Fatal("expected error calling missing method")
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := client.Call(timeout, "Block", &Args{}, &quo); err != context.DeadlineExceeded {
+		t.Fatalf("expected %s, got %v", context.DeadlineExceeded, err)
+	}
+
+	clientConn.Shutdown()
+	if err := client.Call(ctx, "Add", &Args{}, &quo); err != rpc.ErrShutdown {
+		t.Fatalf("expected %s, got %v", rpc.ErrShutdown, err)
+	}
+
+	// Giving up on a request that can't be written
+	stuck, peer := net.Pipe()
+	defer peer.Close()
+	stuckConn, err := newConn(stuck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stuckConn.Shutdown()
+	client = rpc.NewClient(stuckConn, rpcType)
+	timeout, cancel = context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Call(timeout, "Add", &Args{}, &quo)
+	}()
+	select {
+	case err := <-done:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("expected %s, got %v", context.DeadlineExceeded, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Call waited for the request to be written")
+	}
+}
+
+// Arith is served by net/rpc
+type Arith int
+
+// Add adds args
+func (a *Arith) Add(args *Args, reply *int) error {
+	*reply = args.A + args.B
+	return nil
+}
+
+// Divide divides args
+func (a *Arith) Divide(args *Args, reply *int) error {
+	if args.B == 0 {
+		return errors.New("division by zero")
+	}
+	*reply = args.A / args.B
+	return nil
+}
+
+// NetRPC tests net/rpc using codecs alongside other frame types
+func NetRPC(t *testing.T, pool mux.Pool, newConn NewConn) {
+	clientConn, serverConn := connPair(t, newConn)
+	defer clientConn.Shutdown()
+	defer serverConn.Shutdown()
+
+	rpcType := uint8(10)
+
+	logCh := make(chan string, 1)
+	serverConn.Receive(mux.LogType, pool.NewReceiver(logCh))
+
+	server := netrpc.NewServer()
+	if err := server.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	go server.ServeCodec(rpc.NewServerCodec(serverConn, rpcType))
+
+	client := netrpc.NewClientWithCodec(rpc.NewClientCodec(clientConn, rpcType))
+	defer client.Close()
+
+	if err := clientConn.Send(mux.LogType, "hello world"); err != nil {
+		t.Fatal(err)
+	}
+
+	var sum int
+	if err := client.Call("Arith.Add", &Args{A: 1, B: 2}, &sum); err != nil {
+		t.Fatal(err)
+	}
+	if sum != 3 {
+		t.Fatalf("1 + 2 = %d", sum)
+	}
+
+	var quo int
+	if err := client.Call("Arith.Divide", &Args{A: 1}, &quo); err == nil || err.Error() != "division by zero" {
+		t.Fatalf("expected division by zero, got %v", err)
+	}
+
+	if actual := <-logCh; actual != "hello world" {
+		t.Fatalf("'%s' != 'hello world'", actual)
+	}
+
+	// Frames for a closed codec don't block other frame types
+	clientLogCh := make(chan string, 1)
+	clientConn.Receive(mux.LogType, pool.NewReceiver(clientLogCh))
+	client.Close()
+	for i := 0; i < 2*16+1; i++ {
+		if err := serverConn.SendRaw(rpcType, []byte("late")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := serverConn.Send(mux.LogType, "hello world"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case actual := <-clientLogCh:
+		if actual != "hello world" {
+			t.Fatalf("'%s' != 'hello world'", actual)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Recv blocked by a closed codec")
+	}
+}
@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/mux"
+)
+
+// Schema tests checking values and receivers against a mux.Schema and
+// detecting drift from the peer's
+func Schema(t *testing.T, pool mux.Pool, newConn NewConfigConn) {
+	argsType, okType := uint8(10), uint8(11)
+
+	schema := mux.NewSchema()
+	if err := mux.Declare[Args](schema, argsType, "args"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mux.Declare[string](schema, okType, "ok"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mux.Declare[string](schema, argsType, "args"); err != mux.ErrTypeDeclared {
+		t.Fatalf("expected %s, got %v", mux.ErrTypeDeclared, err)
+	}
+	if err := mux.Declare[string](schema, mux.StreamType, "stream"); err != mux.ErrReservedType {
+		t.Fatalf("expected %s, got %v", mux.ErrReservedType, err)
+	}
+
+	client, server := configPair(t, newConn, schemaConfig(schema), schemaConfig(schema))
+	serverArgs := mux.Typed[Args](server, argsType)
+
+	if err := client.Send(argsType, "hello world"); !errors.Is(err, mux.ErrWrongType) {
+		t.Fatalf("expected %s, got %v", mux.ErrWrongType, err)
+	}
+	for _, v := range []interface{}{Args{A: 1}, &Args{A: 1}} {
+		if err := client.Send(argsType, v); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case actual := <-serverArgs.C():
+			if actual.A != 1 {
+				t.Fatalf("expected A 1, got %v", actual)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("value not received")
+		}
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected Receive to panic on a receiver of the wrong type")
+			}
+		}()
+		server.Receive(argsType, pool.NewReceiver(make(chan string)))
+	}()
+	if drift := client.SchemaDrift(); len(drift) != 0 {
+		t.Fatalf("expected no drift, got %v", drift)
+	}
+	client.Shutdown()
+	server.Shutdown()
+
+	// The server declares args differently
+	type otherArgs struct {
+		A, B string
+	}
+	serverSchema := mux.NewSchema()
+	if err := mux.Declare[otherArgs](serverSchema, argsType, "args"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mux.Declare[string](serverSchema, okType, "ok"); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(schema.Fingerprint(), serverSchema.Fingerprint()) {
+		t.Fatal("expected fingerprints to differ")
+	}
+
+	client, server = configPair(t, newConn, schemaConfig(schema), schemaConfig(serverSchema))
+	defer client.Shutdown()
+	defer server.Shutdown()
+
+	for _, conn := range []mux.Conn{client, server} {
+		deadline := time.Now().Add(time.Second)
+		for len(conn.SchemaDrift()) == 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("drift not detected")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if drift := conn.SchemaDrift(); len(drift) != 1 || drift[0] != argsType {
+			t.Fatalf("expected drift of type %d, got %v", argsType, drift)
+		}
+	}
+	if err := client.Send(argsType, Args{}); err != mux.ErrSchemaDrift {
+		t.Fatalf("expected %s, got %v", mux.ErrSchemaDrift, err)
+	}
+
+	okCh := make(chan string, 1)
+	server.Receive(okType, pool.NewReceiver(okCh))
+	if err := client.Send(okType, "hello world"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case actual := <-okCh:
+		if actual != "hello world" {
+			t.Fatalf("'%s' != 'hello world'", actual)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("value not received")
+	}
+}
+
+func schemaConfig(schema *mux.Schema) *mux.Config {
+	return &mux.Config{
+		Timeout: time.Second,
+		Lager:   Lager(),
+		Schema:  schema,
+	}
+}
@@ -0,0 +1,173 @@
+package tests
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/mux"
+)
+
+// connPair returns two connected mux.Conns with Recv running on both
+func connPair(t *testing.T, newConn NewConn) (mux.Conn, mux.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- conn
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := newConn(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := newConn(<-accepted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go client.Recv()
+	go server.Recv()
+	return client, server
+}
+
+// Streams tests echoing data over a logical stream with half-close
+func Streams(t *testing.T, newConn NewConn) {
+	client, server := connPair(t, newConn)
+	defer client.Shutdown()
+	defer server.Shutdown()
+
+	// echo server
+	go func() {
+		s, err := server.AcceptStream()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer s.Close()
+
+		if _, err := io.Copy(s, s); err != nil {
+			t.Error(err)
+		}
+		s.CloseWrite()
+	}()
+
+	expected := bytes.Repeat([]byte("hello world"), 10000)
+
+	s, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	go func() {
+		if _, err := s.Write(expected); err != nil {
+			t.Error(err)
+		}
+		s.CloseWrite()
+	}()
+
+	actual, err := ioutil.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, expected) {
+		t.Fatalf("echoed %d bytes, expected %d", len(actual), len(expected))
+	}
+}
+
+// StreamReset tests that resetting a stream fails both sides
+func StreamReset(t *testing.T, newConn NewConn) {
+	client, server := connPair(t, newConn)
+	defer client.Shutdown()
+	defer server.Shutdown()
+
+	s, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accepted.ID() != s.ID() {
+		t.Fatalf("accepted stream %d != opened stream %d", accepted.ID(), s.ID())
+	}
+
+	if err := s.Reset(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := accepted.Read(make([]byte, 1)); err != mux.ErrStreamReset {
+		t.Fatalf("expected %s, got %v", mux.ErrStreamReset, err)
+	}
+	if _, err := s.Write([]byte("x")); err != mux.ErrStreamReset {
+		t.Fatalf("expected %s, got %v", mux.ErrStreamReset, err)
+	}
+
+	client.Shutdown()
+	if _, err := client.OpenStream(); err != mux.ErrShutdown {
+		t.Fatalf("expected %s, got %v", mux.ErrShutdown, err)
+	}
+}
+
+// StreamFlowControl tests that a writer blocks once the peer's window is full
+func StreamFlowControl(t *testing.T, newConn NewConfigConn) {
+	window := uint32(1024)
+	config := &mux.Config{
+		Timeout:      time.Second,
+		Lager:        Lager(),
+		StreamWindow: window,
+	}
+
+	client, server := connPair(t, func(conn net.Conn) (mux.Conn, error) {
+		return newConn(conn, config)
+	})
+	defer client.Shutdown()
+	defer server.Shutdown()
+
+	s, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := s.Write(make([]byte, 4*window))
+		written <- err
+	}()
+
+	select {
+	case err := <-written:
+		t.Fatalf("write of 4 windows returned %v before being read", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if _, err := io.ReadFull(accepted, make([]byte, 4*window)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/doubledutch/mux"
+)
+
+// Typed tests sending and receiving values with mux.Typed
+func Typed(t *testing.T, newConn NewConn) {
+	client, server := connPair(t, newConn)
+	defer client.Shutdown()
+
+	argsType := uint8(10)
+	clientArgs := mux.Typed[Args](client, argsType)
+	serverArgs := mux.Typed[Args](server, argsType)
+
+	expected := Args{A: 1, B: 2}
+	if err := clientArgs.Send(expected); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case actual := <-serverArgs.C():
+		if actual != expected {
+			t.Fatalf("expected %v, got %v", expected, actual)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("value not received")
+	}
+
+	// Frames that don't decode into Args
+	if err := client.SendRaw(argsType, []byte{0xc1, 0xff, '{'}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-serverArgs.Errors():
+		if err == nil {
+			t.Fatal("expected decode error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("decode error not received")
+	}
+
+	// Values still arrive after an error
+	if err := clientArgs.Send(expected); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case actual := <-serverArgs.C():
+		if actual != expected {
+			t.Fatalf("expected %v, got %v", expected, actual)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("value not received")
+	}
+
+	server.Shutdown()
+	if _, ok := <-serverArgs.C(); ok {
+		t.Fatal("expected C to be closed")
+	}
+	if _, ok := <-serverArgs.Errors(); ok {
+		t.Fatal("expected Errors to be closed")
+	}
+}
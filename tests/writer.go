@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/mux"
+)
+
+// SendQueue tests sending through the writer's queue with each SendPolicy
+func SendQueue(t *testing.T, pool mux.Pool, newConn NewConfigConn) {
+	config := &mux.Config{
+		Timeout: time.Second,
+		Lager:   Lager(),
+	}
+	newDefaultConn := func(conn net.Conn) (mux.Conn, error) {
+		return newConn(conn, config)
+	}
+
+	client, server := connPair(t, newDefaultConn)
+	defer client.Shutdown()
+	defer server.Shutdown()
+
+	n := 10
+	logCh := make(chan string, n*n)
+	server.Receive(mux.LogType, pool.NewReceiver(logCh))
+
+	// concurrent sends all arrive intact
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				if err := <-client.SendAsync(mux.LogType, fmt.Sprintf("%d-%d", i, j)); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	received := make(map[string]bool)
+	for i := 0; i < n*n; i++ {
+		select {
+		case s := <-logCh:
+			received[s] = true
+		case <-time.After(time.Second):
+			t.Fatalf("received %d of %d frames", i, n*n)
+		}
+	}
+	if len(received) != n*n {
+		t.Fatalf("expected %d distinct frames, got %d", n*n, len(received))
+	}
+
+	if _, err := newConn(nil, &mux.Config{Timeout: time.Second, Lager: Lager(), SendQueueSize: -1}); err != mux.ErrInvalidSendQueueSize {
+		t.Fatalf("expected %s, got %v", mux.ErrInvalidSendQueueSize, err)
+	}
+
+	// A full queue fails fast
+	conn := stalledConn(t, newConn, &mux.Config{
+		Timeout:       time.Second,
+		Lager:         Lager(),
+		SendQueueSize: 1,
+		SendPolicy:    mux.SendFailFast,
+	})
+	defer conn.Shutdown()
+
+	text := strings.Repeat("hello world", 10000)
+	timeout := time.After(5 * time.Second)
+full:
+	for {
+		select {
+		case err := <-conn.SendAsync(mux.LogType, text):
+			if err == mux.ErrSendQueueFull {
+				break full
+			}
+			if err != nil {
+				t.Fatalf("expected %s, got %v", mux.ErrSendQueueFull, err)
+			}
+		case <-timeout:
+			t.Fatal("send queue never filled up")
+		default:
+		}
+	}
+
+	// A full queue drops its oldest frame
+	conn = stalledConn(t, newConn, &mux.Config{
+		Timeout:       time.Second,
+		Lager:         Lager(),
+		SendQueueSize: 1,
+		SendPolicy:    mux.SendDropOldest,
+	})
+	defer conn.Shutdown()
+
+	timeout = time.After(5 * time.Second)
+	var sent []<-chan error
+	for {
+		sent = append(sent, conn.SendAsync(mux.LogType, text))
+		for _, done := range sent {
+			select {
+			case err := <-done:
+				if err == mux.ErrFrameDropped {
+					return
+				}
+				if err != nil {
+					t.Fatalf("expected %s, got %v", mux.ErrFrameDropped, err)
+				}
+			default:
+			}
+		}
+
+		select {
+		case <-timeout:
+			t.Fatal("no frame dropped")
+		default:
+		}
+	}
+}
+
+// stalledConn returns a Conn to a peer that never reads
+func stalledConn(t *testing.T, newConn NewConfigConn, config *mux.Config) mux.Conn {
+	mConn, _ := rawPair(t, func(conn net.Conn) (mux.Conn, error) {
+		return newConn(conn, config)
+	})
+	return mConn
+}
@@ -0,0 +1,108 @@
+package mux
+
+import (
+	"context"
+	"reflect"
+)
+
+// TypedReceiver decodes frames into values of type T, it doesn't use
+// reflection like ValueReceiver
+type TypedReceiver[T any] struct {
+	dec BufferDecoder
+	ch  chan T
+	// errs receives decode errors when it isn't nil
+	errs chan error
+}
+
+// NewTypedReceiver creates a TypedReceiver putting values on ch
+func NewTypedReceiver[T any](ch chan T, pool Pool) *TypedReceiver[T] {
+	return &TypedReceiver[T]{
+		dec: pool.NewBufferDecoder(),
+		ch:  ch,
+	}
+}
+
+// Receive decodes b into a T and puts it on ch
+func (r *TypedReceiver[T]) Receive(b []byte) error {
+	var v T
+
+	r.dec.Write(b)
+	err := r.dec.Decode(&v)
+	r.dec.Reset()
+	if err != nil {
+		if r.errs != nil {
+			// Keep the oldest error if it wasn't read yet
+			select {
+			case r.errs <- err:
+			default:
+			}
+		}
+		return err
+	}
+	r.ch <- v
+	return nil
+}
+
+// ResetStream resets the decoder when the sender replaced its encoder
+func (r *TypedReceiver[T]) ResetStream() {
+	ResetStream(r.dec)
+}
+
+// Close and cleans up TypedReceiver
+func (r *TypedReceiver[T]) Close() error {
+	close(r.ch)
+	if r.errs != nil {
+		close(r.errs)
+	}
+	return nil
+}
+
+// ElemType returns T
+func (r *TypedReceiver[T]) ElemType() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// TypedChan sends and receives values of type T on a frame type
+type TypedChan[T any] struct {
+	conn Conn
+	t    uint8
+	ch   chan T
+	errs chan error
+}
+
+// Typed registers a receiver for values of type T on t and returns a
+// TypedChan to send and receive them
+func Typed[T any](conn Conn, t uint8) *TypedChan[T] {
+	r := NewTypedReceiver(make(chan T), conn.Pool())
+	r.errs = make(chan error, 1)
+	conn.Receive(t, r)
+
+	return &TypedChan[T]{
+		conn: conn,
+		t:    t,
+		ch:   r.ch,
+		errs: r.errs,
+	}
+}
+
+// Send encodes v in a frame
+func (c *TypedChan[T]) Send(v T) error {
+	return c.conn.Send(c.t, v)
+}
+
+// SendContext is Send that gives up once ctx is done
+func (c *TypedChan[T]) SendContext(ctx context.Context, v T) error {
+	return c.conn.SendContext(ctx, c.t, v)
+}
+
+// C returns the channel received values are put on, it's closed when the
+// connection shuts down
+func (c *TypedChan[T]) C() <-chan T {
+	return c.ch
+}
+
+// Errors returns the channel receiving errors decoding frames into a T. The
+// oldest unread error is kept, later ones are only logged by Recv.
+func (c *TypedChan[T]) Errors() <-chan error {
+	return c.errs
+}
@@ -1,6 +1,7 @@
 package mux
 
 import (
+	"context"
 	"errors"
 	"time"
 
@@ -16,11 +17,44 @@ const (
 	SignalType
 )
 
+const (
+	// StreamType is reserved for logical stream frames
+	StreamType uint8 = 255 - iota
+	// PingType is reserved for heartbeats
+	PingType
+	// GoAwayType is reserved for graceful shutdown
+	GoAwayType
+	// SchemaType is reserved for exchanging Schema fingerprints
+	SchemaType
+	// AckType is reserved for acknowledging frames of reliable types
+	AckType
+)
+
+// reserved returns whether t is reserved for the Conn's own frames
+func reserved(t uint8) bool {
+	return t >= AckType
+}
+
 var (
 	// ErrInvalidTimeout defines an error for an invalid Config Timeout value
 	ErrInvalidTimeout = errors.New("Invalid Config Timeout")
 	// ErrInvalidLager defines an error for an invalid Config Lager value
 	ErrInvalidLager = errors.New("Invalid Lager")
+	// ErrInvalidPingInterval defines an error for an invalid Config
+	// PingInterval value
+	ErrInvalidPingInterval = errors.New("Invalid Config PingInterval")
+	// ErrInvalidMaxMissedPings defines an error for an invalid Config
+	// MaxMissedPings value
+	ErrInvalidMaxMissedPings = errors.New("Invalid Config MaxMissedPings")
+	// ErrInvalidDrainTimeout defines an error for an invalid Config
+	// DrainTimeout value
+	ErrInvalidDrainTimeout = errors.New("Invalid Config DrainTimeout")
+	// ErrInvalidSendQueueSize defines an error for an invalid Config
+	// SendQueueSize value
+	ErrInvalidSendQueueSize = errors.New("Invalid Config SendQueueSize")
+	// ErrInvalidSendPolicy defines an error for an invalid Config SendPolicy
+	// value
+	ErrInvalidSendPolicy = errors.New("Invalid Config SendPolicy")
 )
 
 // Config configures a Server or Client
@@ -30,6 +64,69 @@ type Config struct {
 
 	// Lager is used to control the log destination
 	Lager lager.Lager
+
+	// StreamWindow is the receive window, in bytes, advertised for each
+	// Stream. Writers block once the peer's window is exhausted. Zero uses
+	// DefaultStreamWindow.
+	StreamWindow uint32
+
+	// PingInterval is how often the peer is pinged, zero disables heartbeats
+	PingInterval time.Duration
+
+	// MaxMissedPings is how many pings in a row may go unanswered before the
+	// connection is shutdown with ErrHeartbeatTimeout. Zero uses
+	// DefaultMaxMissedPings.
+	MaxMissedPings int
+
+	// DrainTimeout bounds how long GoAway waits for the peer to deliver the
+	// frames it has in flight. Zero uses DefaultDrainTimeout.
+	DrainTimeout time.Duration
+
+	// SendQueueSize is how many frames Send can queue for writing. Zero uses
+	// DefaultSendQueueSize.
+	SendQueueSize int
+
+	// SendPolicy decides what Send does when the queue is full
+	SendPolicy SendPolicy
+
+	// Registry holds the encodings NewClientConn and NewServerConn
+	// negotiate, nil uses DefaultRegistry
+	Registry *Registry
+
+	// Encodings are the names of the encodings to negotiate, in order of
+	// preference. Empty uses every encoding in the Registry.
+	Encodings []string
+
+	// HandshakeTimeout bounds encoding negotiation. Zero uses
+	// DefaultHandshakeTimeout.
+	HandshakeTimeout time.Duration
+
+	// DisableCapabilities are capabilities not offered to the peer.
+	// Disabling all but CapHeartbeat, CapStreams and CapGoAway sends a
+	// version 1 hello, so peers built before capabilities can still connect
+	// while a fleet is upgraded.
+	DisableCapabilities Capabilities
+
+	// Schema declares the frame types sent and received, it's checked by
+	// Send and Receive and compared with the peer's
+	Schema *Schema
+
+	// FragmentSize is the largest frame data a message is sent in when the
+	// peer supports CapFragments, larger messages are split into fragments.
+	// Zero uses DefaultFragmentSize.
+	FragmentSize int
+
+	// MaxMessageSize is the largest encoded message sent or reassembled,
+	// Send fails with ErrMessageTooLarge and Recv drops larger ones. It
+	// bounds the memory a peer can make Recv buffer, messages above
+	// MaxFrameSize also need a peer supporting CapFragments. Zero uses
+	// DefaultMaxMessageSize, four times MaxFrameSize.
+	MaxMessageSize int
+
+	// Reliable holds the frame types delivered at least once when the peer
+	// supports CapReliable. Passing the Reliable of a dropped Conn redelivers
+	// its frames that weren't acknowledged. Nil sends no reliable types.
+	Reliable *Reliable
 }
 
 // Verify validates the config
@@ -42,10 +139,77 @@ func (c *Config) Verify() error {
 		return ErrInvalidLager
 	}
 
+	if c.PingInterval < 0 {
+		return ErrInvalidPingInterval
+	}
+
+	if c.MaxMissedPings < 0 {
+		return ErrInvalidMaxMissedPings
+	}
+
+	if c.DrainTimeout < 0 {
+		return ErrInvalidDrainTimeout
+	}
+
+	if c.SendQueueSize < 0 {
+		return ErrInvalidSendQueueSize
+	}
+
+	if c.SendPolicy < SendBlock || c.SendPolicy > SendDropOldest {
+		return ErrInvalidSendPolicy
+	}
+
+	if len(c.Encodings) > maxNegotiateEncodings {
+		return ErrInvalidEncodings
+	}
+
+	if c.HandshakeTimeout < 0 {
+		return ErrInvalidHandshakeTimeout
+	}
+
+	if c.FragmentSize < 0 || c.FragmentSize > MaxFrameSize {
+		return ErrInvalidFragmentSize
+	}
+
+	if c.MaxMessageSize < 0 {
+		return ErrInvalidMaxMessageSize
+	}
+
 	return nil
 }
 
-// DefaultConfig creates config with default settings
+// negotiable returns the Registry and the encodings in it to negotiate
+func (c *Config) negotiable() (*Registry, []string, error) {
+	registry := c.Registry
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+
+	encodings := c.Encodings
+	if len(encodings) == 0 {
+		encodings = registry.Names()
+	}
+	// Verify only checks Encodings, not the Registry's names
+	if len(encodings) > maxNegotiateEncodings {
+		return nil, nil, ErrInvalidEncodings
+	}
+	for _, name := range encodings {
+		if registry.Pool(name) == nil {
+			return nil, nil, ErrInvalidEncodings
+		}
+	}
+	return registry, encodings, nil
+}
+
+func (c *Config) handshakeTimeout() time.Duration {
+	if c.HandshakeTimeout == 0 {
+		return DefaultHandshakeTimeout
+	}
+	return c.HandshakeTimeout
+}
+
+// DefaultConfig creates config with default settings, heartbeats are off
+// until PingInterval is set
 func DefaultConfig() *Config {
 	return &Config{
 		Timeout: 100 * time.Millisecond,
@@ -86,6 +250,7 @@ func (s *server) Done(err error) {
 type Client interface {
 	Conn
 	Wait() error
+	WaitContext(ctx context.Context) error
 }
 
 type client struct {
@@ -112,10 +277,27 @@ func NewClient(conn Conn) (Client, error) {
 // Wait waits for an error from Server then closes the connection.
 // When this returns, the server is done sending.
 func (c *client) Wait() error {
-	errStr := <-c.errCh
+	return c.WaitContext(context.Background())
+}
+
+// WaitContext is Wait that returns ctx.Err() once ctx is done, leaving the
+// connection open
+func (c *client) WaitContext(ctx context.Context) error {
+	var errStr string
+	var ok bool
+	select {
+	case errStr, ok = <-c.errCh:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 
 	c.Shutdown()
 
+	// The connection shutdown before the server was done
+	if !ok && c.Err() != nil {
+		return c.Err()
+	}
+
 	if errStr == "" {
 		return nil
 	}
@@ -0,0 +1,184 @@
+// Package union carries several message types on one frame type. Each
+// frame holds a tag naming the concrete type followed by the value, both
+// encoded with the Conn's Pool, so it works with any encoding.
+package union
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/doubledutch/mux"
+)
+
+var (
+	// ErrUnregistered is returned by Send for a value whose type wasn't
+	// registered
+	ErrUnregistered = errors.New("union: unregistered type")
+	// ErrUnknownTag is returned when receiving a tag that wasn't registered
+	ErrUnknownTag = errors.New("union: unknown tag")
+	// ErrTagRegistered is returned when registering a tag twice
+	ErrTagRegistered = errors.New("union: tag already registered")
+	// ErrTypeRegistered is returned when registering a type twice
+	ErrTypeRegistered = errors.New("union: type already registered")
+)
+
+// Union maps tags to the concrete types sent on a frame type. Both peers
+// must register the same tags.
+type Union struct {
+	lock  sync.RWMutex
+	types map[string]reflect.Type
+	tags  map[reflect.Type]string
+}
+
+// New creates an empty Union
+func New() *Union {
+	return &Union{
+		types: make(map[string]reflect.Type),
+		tags:  make(map[reflect.Type]string),
+	}
+}
+
+// Register registers the concrete type of value as tag. Values are
+// delivered as that type, e.g. registering a pointer delivers pointers.
+func (u *Union) Register(tag string, value interface{}) error {
+	typ := reflect.TypeOf(value)
+	if typ == nil {
+		return fmt.Errorf("union: can't register nil as %s", tag)
+	}
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if _, ok := u.types[tag]; ok {
+		return ErrTagRegistered
+	}
+	if _, ok := u.tags[typ]; ok {
+		return ErrTypeRegistered
+	}
+	u.types[tag] = typ
+	u.tags[typ] = tag
+	return nil
+}
+
+// Register registers T as tag
+func Register[T any](u *Union, tag string) error {
+	var v T
+	return u.Register(tag, v)
+}
+
+// tag returns the tag of v, a pointer may be sent for a registered value
+// type and the other way around
+func (u *Union) tag(v interface{}) (string, error) {
+	typ := reflect.TypeOf(v)
+	if typ == nil {
+		return "", ErrUnregistered
+	}
+
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+	if tag, ok := u.tags[typ]; ok {
+		return tag, nil
+	}
+	if typ.Kind() == reflect.Ptr {
+		if tag, ok := u.tags[typ.Elem()]; ok {
+			return tag, nil
+		}
+	}
+	if tag, ok := u.tags[reflect.PointerTo(typ)]; ok {
+		return tag, nil
+	}
+	return "", fmt.Errorf("%w %T", ErrUnregistered, v)
+}
+
+// Send sends v on t tagged with its registered tag. Frames on t must only be
+// sent through the Union.
+func (u *Union) Send(conn mux.Conn, t uint8, v interface{}) error {
+	tag, err := u.tag(v)
+	if err != nil {
+		return err
+	}
+
+	// Every frame has its own encoder, so it can be decoded on its own
+	enc := conn.Pool().NewBufferEncoder()
+	if err := enc.Encode(tag); err != nil {
+		return err
+	}
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	return conn.SendRaw(t, enc.Bytes())
+}
+
+// decode decodes a frame into its registered type
+func (u *Union) decode(pool mux.Pool, b []byte) (interface{}, error) {
+	dec := pool.NewBufferDecoder()
+	dec.Write(b)
+
+	var tag string
+	if err := dec.Decode(&tag); err != nil {
+		return nil, err
+	}
+	u.lock.RLock()
+	typ, ok := u.types[tag]
+	u.lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownTag, tag)
+	}
+
+	v := reflect.New(typ)
+	if err := dec.Decode(v.Interface()); err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
+
+// Receiver decodes frames into their registered concrete types
+type Receiver struct {
+	u      *Union
+	pool   mux.Pool
+	handle func(v interface{}) error
+	close  func()
+}
+
+// NewReceiver creates a Receiver putting values on ch, it's closed with the
+// Conn
+func (u *Union) NewReceiver(ch chan interface{}, pool mux.Pool) *Receiver {
+	return &Receiver{
+		u:    u,
+		pool: pool,
+		handle: func(v interface{}) error {
+			ch <- v
+			return nil
+		},
+		close: func() {
+			close(ch)
+		},
+	}
+}
+
+// NewHandler creates a Receiver calling handle with each value, usually
+// with a type switch. Errors it returns are logged by Recv.
+func (u *Union) NewHandler(handle func(v interface{}) error, pool mux.Pool) *Receiver {
+	return &Receiver{
+		u:      u,
+		pool:   pool,
+		handle: handle,
+		close:  func() {},
+	}
+}
+
+// Receive decodes b and delivers the value
+func (r *Receiver) Receive(b []byte) error {
+	v, err := r.u.decode(r.pool, b)
+	if err != nil {
+		return err
+	}
+	return r.handle(v)
+}
+
+// Close and cleans up Receiver
+func (r *Receiver) Close() error {
+	r.close()
+	return nil
+}
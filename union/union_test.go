@@ -0,0 +1,194 @@
+package union
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/mux"
+	"github.com/doubledutch/mux/cbor"
+	"github.com/doubledutch/mux/gob"
+	"github.com/doubledutch/mux/json"
+	"github.com/doubledutch/mux/msgpack"
+)
+
+var pools = map[string]mux.Pool{
+	"gob":     new(gob.Pool),
+	"json":    new(json.Pool),
+	"msgpack": new(msgpack.Pool),
+	"cbor":    new(cbor.Pool),
+}
+
+type Started struct {
+	ID string
+}
+
+type Progress struct {
+	Percent int
+}
+
+type Finished struct {
+	Err string
+}
+
+const jobType = uint8(10)
+
+func newUnion(t *testing.T) *Union {
+	u := New()
+	if err := Register[Started](u, "started"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Register("progress", Progress{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Register("finished", &Finished{}); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func connPair(t *testing.T, pool mux.Pool) (mux.Conn, mux.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := mux.NewConn(conn, pool, mux.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := mux.NewConn(accepted, pool, mux.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go client.Recv()
+	go server.Recv()
+	return client, server
+}
+
+func TestRegister(t *testing.T) {
+	u := newUnion(t)
+	if err := u.Register("started", Progress{}); err != ErrTagRegistered {
+		t.Fatalf("expected %s, got %v", ErrTagRegistered, err)
+	}
+	if err := u.Register("other", Started{}); err != ErrTypeRegistered {
+		t.Fatalf("expected %s, got %v", ErrTypeRegistered, err)
+	}
+	if err := u.Register("nil", nil); err == nil {
+		t.Fatal("expected error registering nil")
+	}
+}
+
+func TestReceiver(t *testing.T) {
+	for name, pool := range pools {
+		t.Run(name, func(t *testing.T) {
+			u := newUnion(t)
+			client, server := connPair(t, pool)
+			defer client.Shutdown()
+
+			ch := make(chan interface{}, 3)
+			server.Receive(jobType, u.NewReceiver(ch, pool))
+
+			// Pointers and values of registered types are both sent
+			sent := []interface{}{&Started{ID: "job"}, Progress{Percent: 50}, Finished{Err: "done"}}
+			expected := []interface{}{Started{ID: "job"}, Progress{Percent: 50}, &Finished{Err: "done"}}
+			for _, v := range sent {
+				if err := u.Send(client, jobType, v); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, e := range expected {
+				select {
+				case actual := <-ch:
+					if !reflect.DeepEqual(actual, e) {
+						t.Fatalf("expected %#v, got %#v", e, actual)
+					}
+				case <-time.After(time.Second):
+					t.Fatal("value not received")
+				}
+			}
+
+			if err := u.Send(client, jobType, "hello world"); !errors.Is(err, ErrUnregistered) {
+				t.Fatalf("expected %s, got %v", ErrUnregistered, err)
+			}
+
+			server.Shutdown()
+			if _, ok := <-ch; ok {
+				t.Fatal("expected ch to be closed")
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	for name, pool := range pools {
+		t.Run(name, func(t *testing.T) {
+			u := newUnion(t)
+			client, server := connPair(t, pool)
+			defer client.Shutdown()
+			defer server.Shutdown()
+
+			percent := make(chan int, 1)
+			finished := make(chan string, 1)
+			server.Receive(jobType, u.NewHandler(func(v interface{}) error {
+				switch v := v.(type) {
+				case Progress:
+					percent <- v.Percent
+				case *Finished:
+					finished <- v.Err
+				default:
+					t.Errorf("unexpected %#v", v)
+				}
+				return nil
+			}, pool))
+
+			if err := u.Send(client, jobType, Progress{Percent: 10}); err != nil {
+				t.Fatal(err)
+			}
+			if err := u.Send(client, jobType, &Finished{}); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case p := <-percent:
+				if p != 10 {
+					t.Fatalf("expected 10, got %d", p)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("progress not received")
+			}
+			select {
+			case <-finished:
+			case <-time.After(time.Second):
+				t.Fatal("finished not received")
+			}
+		})
+	}
+}
+
+func TestUnknownTag(t *testing.T) {
+	// A peer that registered a tag we didn't
+	pool := new(json.Pool)
+	enc := pool.NewBufferEncoder()
+	enc.Encode("cancelled")
+	enc.Encode(Finished{})
+
+	r := newUnion(t).NewHandler(func(v interface{}) error {
+		t.Fatalf("unexpected %#v", v)
+		return nil
+	}, pool)
+	if err := r.Receive(enc.Bytes()); !errors.Is(err, ErrUnknownTag) {
+		t.Fatalf("expected %s, got %v", ErrUnknownTag, err)
+	}
+}
@@ -0,0 +1,223 @@
+package mux
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	// DefaultSendQueueSize is used when the Config doesn't specify
+	// SendQueueSize
+	DefaultSendQueueSize = 64
+	// controlQueueSize is the number of control frames that can be queued
+	controlQueueSize = 64
+)
+
+var (
+	// ErrSendQueueFull is returned by Send with SendFailFast when the send
+	// queue is full
+	ErrSendQueueFull = errors.New("Send queue full")
+	// ErrFrameDropped is returned by Send with SendDropOldest when its frame
+	// was dropped to make room for a newer one
+	ErrFrameDropped = errors.New("Frame dropped")
+)
+
+// SendPolicy decides what Send does when the send queue is full
+type SendPolicy int
+
+const (
+	// SendBlock waits for room in the queue
+	SendBlock SendPolicy = iota
+	// SendFailFast returns ErrSendQueueFull
+	SendFailFast
+	// SendDropOldest drops the oldest queued frame, its Send returns
+	// ErrFrameDropped
+	SendDropOldest
+)
+
+// outFrame is a frame waiting for the writer
+type outFrame struct {
+	frame Frame
+	ctx   context.Context
+	done  chan error
+	// sent is true for frames queued by Send, which GoAway waits for
+	sent bool
+}
+
+func newOutFrame(ctx context.Context, f Frame) *outFrame {
+	return &outFrame{
+		frame: f,
+		ctx:   ctx,
+		done:  make(chan error, 1),
+	}
+}
+
+// complete reports the result of writing f
+func (c *conn) complete(f *outFrame, err error) {
+	f.done <- err
+	if f.sent {
+		c.goAway.endSend()
+	}
+}
+
+// writer owns the net.Conn for writing. Control frames go first, then data
+// frames from Send, fragments and frames from streams take turns.
+func (c *conn) writer() {
+	// Send the hello right away so the peer learns our capabilities
+	if err := c.fw.flush(); err != nil {
+		select {
+		case <-c.ShutdownCh:
+		default:
+			c.lgr.Errorf("Unable to send hello: %s", err)
+			c.shutdown(err)
+		}
+	}
+
+	for {
+		var f *outFrame
+		select {
+		case f = <-c.control:
+		default:
+			select {
+			case f = <-c.control:
+			case f = <-c.queue:
+			case f = <-c.streamQueue:
+			case f = <-c.fragmentQueue:
+			case <-c.ShutdownCh:
+				return
+			}
+		}
+		select {
+		case <-c.ShutdownCh:
+			c.complete(f, ErrShutdown)
+		default:
+			c.complete(f, c.write(f))
+		}
+	}
+}
+
+// write encodes f on conn, using write deadlines to give up once its ctx is
+// done. Since a frame can't be left half written, the connection is shutdown
+// if that happens while writing.
+func (c *conn) write(f *outFrame) error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+	c.lgr.Debugf("Sending frame: %v\n", f.frame)
+
+	if f.ctx.Done() == nil {
+		return c.fw.write(f.frame)
+	}
+
+	deadline, _ := f.ctx.Deadline()
+	c.conn.SetWriteDeadline(deadline)
+	stop := afterDone(f.ctx, c.conn.SetWriteDeadline)
+	err := c.fw.write(f.frame)
+	stop()
+	c.conn.SetWriteDeadline(time.Time{})
+
+	// Only ctx sets write deadlines, its timer may just not have fired yet
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		<-f.ctx.Done()
+	}
+	if err != nil && f.ctx.Err() != nil {
+		c.shutdown(f.ctx.Err())
+		return f.ctx.Err()
+	}
+	return err
+}
+
+// enqueue puts f on queue, waiting for room until ctx is done
+func (c *conn) enqueue(ctx context.Context, queue chan *outFrame, f *outFrame) error {
+	select {
+	case queue <- f:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.ShutdownCh:
+		return ErrShutdown
+	}
+}
+
+// enqueueSend puts a frame from Send on the send queue following the
+// Config's SendPolicy
+func (c *conn) enqueueSend(ctx context.Context, f *outFrame) error {
+	select {
+	case <-c.ShutdownCh:
+		return ErrShutdown
+	default:
+	}
+
+	switch c.sendPolicy {
+	case SendFailFast:
+		select {
+		case c.queue <- f:
+			return nil
+		default:
+			return ErrSendQueueFull
+		}
+	case SendDropOldest:
+		for {
+			select {
+			case c.queue <- f:
+				return nil
+			default:
+			}
+			select {
+			case old := <-c.queue:
+				c.complete(old, ErrFrameDropped)
+			default:
+			}
+		}
+	default:
+		return c.enqueue(ctx, c.queue, f)
+	}
+}
+
+// wait waits for f to be written, giving up once ctx is done
+func (c *conn) wait(ctx context.Context, f *outFrame) error {
+	select {
+	case err := <-f.done:
+		return err
+	case <-ctx.Done():
+	case <-c.ShutdownCh:
+	}
+
+	// It may have been written in the meantime
+	select {
+	case err := <-f.done:
+		return err
+	default:
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return ErrShutdown
+}
+
+// sendFrame queues a control frame and waits for it to be written
+func (c *conn) sendFrame(f Frame) error {
+	return c.sendFrameContext(context.Background(), c.control, f)
+}
+
+// sendFrameContext queues f on queue and waits for it to be written until
+// ctx is done
+func (c *conn) sendFrameContext(ctx context.Context, queue chan *outFrame, f Frame) error {
+	out := newOutFrame(ctx, f)
+	if err := c.enqueue(ctx, queue, out); err != nil {
+		return err
+	}
+	return c.wait(ctx, out)
+}
+
+// queueFrame queues a control frame without waiting for it to be written,
+// used while receiving so Recv never waits on the writer
+func (c *conn) queueFrame(f Frame) {
+	out := newOutFrame(context.Background(), f)
+	if err := c.enqueue(context.Background(), c.control, out); err != nil {
+		c.lgr.Debugf("Unable to queue frame %d: %s", f.Type, err)
+	}
+}